@@ -1,9 +1,8 @@
 package adi
 
 import (
+	"context"
 	"crypto/rand"
-	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,10 +10,14 @@ import (
 	"math/big"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/nlopes/slack"
@@ -23,7 +26,12 @@ import (
 type Message struct {
 	Text      string
 	User      *User
+	Channel   string
 	Timestamp string
+	Args      Args
+	Context   context.Context
+	// Command is the name of the command the message runs.
+	Command string
 }
 
 type Response struct {
@@ -37,9 +45,10 @@ type Level uint8
 type Points uint64
 
 type User struct {
-	ID     string `json:"id"`
-	Level  Level  `json:"level"`
-	Points Points `json:"points"`
+	ID     string   `json:"id"`
+	Level  Level    `json:"level"`
+	Points Points   `json:"points"`
+	Roles  []string `json:"roles,omitempty"`
 }
 
 type Bank struct {
@@ -53,39 +62,94 @@ type Bank struct {
 		Invest      Points            `json:"invest"`
 		TicketPrice Points            `json:"ticket_price"`
 	} `json:"lottery"`
+	Salary struct {
+		Mode             string            `json:"mode"`
+		Rate             Points            `json:"rate"`
+		Every            time.Duration     `json:"every"`
+		DailyCap         Points            `json:"daily_cap"`
+		LevelMultipliers map[Level]float64 `json:"level_multipliers"`
+		ExcludedUsers    []string          `json:"excluded_users"`
+		ExcludedChannels []string          `json:"excluded_channels"`
+		LastPaid         time.Time         `json:"last_paid"`
+		Day              string            `json:"day"`
+		Earned           map[string]Points `json:"earned"`
+	} `json:"salary"`
 }
 
 type Account interface {
 	Add(Points)
 	Sub(Points)
 	Balance() Points
+	AccountID() string
 }
 
-type CommandFunc func(m Message, rtm *slack.RTM) Response
+type account struct {
+	id string
+	*Points
+}
+
+func (a account) AccountID() string { return a.id }
 
+type CommandFunc func(m Message, t Transport) Response
+
+// Command is a command as it is used. It is stored as commandOverride,
+// so only what differs from the defaults of its module is kept.
 type Command struct {
-	Name          string      `json:"name"`
-	RequiredLevel Level       `json:"required_level"`
-	Price         Points      `json:"price"`
-	Visible       bool        `json:"visible"`
-	Proxy         string      `json:"proxy,omitempty"`
-	Func          CommandFunc `json:"-"`
+	Name          string
+	RequiredLevel Level
+	Price         Points
+	Visible       bool
+	Proxy         string
+	Creator       string // id of the user who set the proxy
+	Timeout       time.Duration
+	Cooldown      Cooldown
+	Description   string
+	Usage         string
+	Examples      []string
+	Module        string
+	Func          CommandFunc
+	Spec          *Spec
 }
 
+const (
+	// DefaultTimeout is used for commands without a timeout.
+	DefaultTimeout = time.Second * 30
+	DefaultWorkers = 8
+	// directoryResync is how often the directory is refreshed in case
+	// events were missed.
+	directoryResync = time.Minute * 30
+	// typingAfter is how long a command may take before adi shows
+	// that it is working on it.
+	typingAfter = time.Second
+	// maxProxyDepth is how many proxies may run one another.
+	maxProxyDepth = 8
+	// maxSteps is how many commands a proxy may run.
+	maxSteps = 20
+	// watchEvery is how often config.json and commands.json are
+	// checked for changes.
+	watchEvery = time.Second * 5
+)
+
 var (
 	DubtrackRoom string
 	DefaultLevel Level
 	GlobalBank   Bank
-	Users        []User
-	Commands     []Command
+	Users        []*User
+	Commands     []*Command
 
-	helpString   string
 	commandFuncs = map[string]CommandFunc{}
+	commandSpecs = map[string]*Spec{}
 	reCommand    *regexp.Regexp
+	reToMe       *regexp.Regexp
+	botID        string
 	reUrlUnFurl  = regexp.MustCompile(
 		"<((?:https?|ftp)://[^|>]+)(?:|[^>]+)?>")
 )
 
+// commandModules are the names of the packages that registered the
+// funcs.
+var commandModules = map[string]string{}
+
 func (u *Points) Balance() Points { return *u }
 
 func (u *Points) Add(p Points) {
@@ -104,10 +168,42 @@ func (u *Points) Sub(p Points) {
 	}
 }
 
+func UserAccount(u *User) Account { return account{u.ID, &u.Points} }
+
+func BankAccount() Account { return account{"bank", &GlobalBank.Points} }
+
+func PotAccount() Account { return account{"pot", &GlobalBank.Lottery.Pot} }
+
+// accountByID returns the account with id or nil.
+func accountByID(id string) Account {
+	switch id {
+	case "bank":
+		return BankAccount()
+	case "pot":
+		return PotAccount()
+	}
+	for _, u := range Users {
+		if u.ID == id {
+			return UserAccount(u)
+		}
+	}
+	return nil
+}
+
+// accounts returns the bank, the pot and every user.
+func accounts() []Account {
+	as := make([]Account, 0, len(Users)+2)
+	as = append(as, BankAccount(), PotAccount())
+	for _, u := range Users {
+		as = append(as, UserAccount(u))
+	}
+	return as
+}
+
 func GetCommandByName(name string) *Command {
-	for i, _ := range Commands {
-		if Commands[i].Name == name {
-			return &Commands[i]
+	for _, c := range Commands {
+		if c.Name == name {
+			return c
 		}
 	}
 	return nil
@@ -154,60 +250,45 @@ func MulOverflows(a, b uint64) bool {
 	return c/b != a
 }
 
-func GetUserByName(rtm *slack.RTM, name string) *slack.User {
-	us, err := rtm.GetUsers()
-	if err != nil {
-		log.Println("ERROR:", err.Error())
-		return nil
-	}
-	for i, o := range us {
-		if o.Name == name {
-			return &us[i]
-		}
-	}
-	return nil
+// GetUserByName resolves name as described in ResolveUser.
+func GetUserByName(t Transport, name string) *ChatUser {
+	return ResolveUser(t, name)
 }
 
-func GetAccountByName(rtm *slack.RTM, name string) Account {
+// GetAccountByName returns the bank, the lottery pot or the user called
+// name. The state has to be locked.
+func GetAccountByName(t Transport, name string) Account {
 	if name == "bank" {
-		return &GlobalBank.Points
+		return BankAccount()
 	}
 	if name == "pot" {
-		return &GlobalBank.Lottery.Pot
+		return PotAccount()
 	}
-	us := GetUserByName(rtm, name)
+	us := GetUserByName(t, name)
 	if us == nil {
 		return nil
 	}
-	return &GetCreateUser(us.ID).Points
+	return UserAccount(GetCreateUser(us.ID))
 }
 
-func GetChannelByName(rtm *slack.RTM, name string) *slack.Channel {
-	cs, err := rtm.GetChannels(true)
-	if err != nil {
-		log.Println("ERROR:", err.Error())
+// GetChannelByName resolves a public channel as described in
+// ResolveChannel.
+func GetChannelByName(t Transport, name string) *Channel {
+	c := ResolveChannel(t, name)
+	if c == nil || c.Private {
 		return nil
 	}
-	for i, c := range cs {
-		if c.Name == name {
-			return &cs[i]
-		}
-	}
-	return nil
+	return c
 }
 
-func GetGroupByName(rtm *slack.RTM, name string) *slack.Group {
-	cs, err := rtm.GetGroups(true)
-	if err != nil {
-		log.Println("ERROR:", err.Error())
+// GetGroupByName resolves a private channel as described in
+// ResolveChannel.
+func GetGroupByName(t Transport, name string) *Channel {
+	c := ResolveChannel(t, name)
+	if c == nil || !c.Private {
 		return nil
 	}
-	for i, c := range cs {
-		if c.Name == name {
-			return &cs[i]
-		}
-	}
-	return nil
+	return c
 }
 
 func ParsePoints(src Account, name, text string) (Points, string) {
@@ -242,15 +323,18 @@ func ParsePoints(src Account, name, text string) (Points, string) {
 	return n, ""
 }
 
+// GetCreateUser returns the user with id and creates it if it does not
+// exist yet. The state has to be locked.
 func GetCreateUser(id string) *User {
-	for i, _ := range Users {
-		if Users[i].ID == id {
-			return &Users[i]
+	for _, u := range Users {
+		if u.ID == id {
+			return u
 		}
 	}
-	user := User{ID: id, Level: DefaultLevel, Points: 0}
+	user := &User{ID: id, Level: DefaultLevel, Points: 0}
 	Users = append(Users, user)
-	return &Users[len(Users)-1]
+	Changed()
+	return user
 }
 
 func Uniq(data sort.Interface) (size int) {
@@ -270,31 +354,26 @@ func Uniq(data sort.Interface) (size int) {
 	return p + 1
 }
 
-func GetIMChannels(rtm *slack.RTM, pids []string) []slack.IM {
-	imcs, err := rtm.GetIMChannels()
-	if err != nil {
-		log.Println("ERROR:", err)
-		return nil
-	}
-	wanted := make([]slack.IM, 0, len(pids))
-loop:
-	for _, imc := range imcs {
-		for _, pid := range pids {
-			if imc.User == pid {
-				wanted = append(wanted, imc)
-				continue loop
-			}
+func GetIMChannels(t Transport, pids []string) []string {
+	imcs := make([]string, 0, len(pids))
+	for _, pid := range pids {
+		imc, err := t.OpenIMChannel(pid)
+		if err != nil {
+			log.Println("ERROR:", err)
+			continue
 		}
+		imcs = append(imcs, imc)
 	}
-	return wanted
+	return imcs
 }
 
-func drawLottery(rtm *slack.RTM) {
+func drawLottery(t Transport) {
 	lot := &GlobalBank.Lottery
 	nextDraw := lot.LastDraw.Add(GlobalBank.Lottery.DrawEvery).UTC()
 	if time.Now().UTC().Before(nextDraw) {
 		return
 	}
+	Changed()
 	if lot.TicketsSold == 0 {
 		lot.LastDraw = time.Now().UTC()
 		return
@@ -321,17 +400,10 @@ func drawLottery(rtm *slack.RTM) {
 				break
 			}
 		}
-		var dst Account
-		dst = &GetCreateUser(w).Points
-		var name string
-		{
-			us, err := rtm.GetUserInfo(w)
-			if err != nil {
-				log.Println("ERROR:", err)
-				name = "somebody"
-			} else {
-				name = us.Name
-			}
+		dst := UserAccount(GetCreateUser(w))
+		name := "somebody"
+		if us := ChatUserByID(w); us != nil {
+			name = us.Name
 		}
 		m := fmt.Sprintf("%s won the lottery pot of %d points",
 			name,
@@ -342,29 +414,40 @@ func drawLottery(rtm *slack.RTM) {
 		for _, p := range participants {
 			pids = append(pids, p.ID)
 		}
-		imcs := GetIMChannels(rtm, pids)
-		if imcs != nil {
-			for _, imc := range imcs {
-				rtm.SendMessage(rtm.NewOutgoingMessage(m, imc.ID))
-			}
+		for _, imc := range GetIMChannels(t, pids) {
+			t.SendMessage(imc, m)
 		}
-		dst.Add(lot.Pot)
-		lot.Pot = 0
+		Transfer(PotAccount(), dst, lot.Pot, "lottery draw", "")
 		lot.TicketsSold = 0
 		lot.Tickets = map[string]uint64{}
 	}
 	bi := lot.Invest
 	if GlobalBank.Points.Balance() > bi {
-		GlobalBank.Points.Sub(bi)
-		lot.Pot.Add(bi)
+		Transfer(BankAccount(), PotAccount(), bi, "lottery invest", "")
 	}
 	lot.LastDraw = time.Now().UTC()
 }
 
+// ResetCommands has to be called with the state locked whenever
+// Commands changed. Registered funcs without command are added with
+// the defaults of their module.
 func ResetCommands() {
+	Changed()
+	for _, name := range RegisteredFuncs() {
+		if GetCommandByName(name) == nil {
+			c := defaultCommand(name)
+			Commands = append(Commands, &c)
+		}
+	}
 	commandStrings := make([]string, len(Commands))
 	for i, _ := range Commands {
-		commandStrings[i] = Commands[i].Name
+		name := Commands[i].Name
+		if f, ok := commandFuncs[name]; ok {
+			Commands[i].Func = f
+			Commands[i].Spec = commandSpecs[name]
+			Commands[i].Module = commandModules[name]
+		}
+		commandStrings[i] = regexp.QuoteMeta(name)
 	}
 	reCommand = regexp.MustCompile(
 		fmt.Sprintf("(?s)^(%s)(?:\\s+(.+))?\\s*$",
@@ -374,11 +457,11 @@ func ResetCommands() {
 func parseCommand(text string) (*Command, string, error) {
 	m := reCommand.FindStringSubmatch(text)
 	if m == nil {
-		return nil, "", errors.New("commands: " + helpString)
+		return nil, "", errUnknownCommand
 	}
 	cmd := GetCommandByName(m[1])
 	if cmd == nil {
-		return nil, "", errors.New("commands: " + helpString)
+		return nil, "", errUnknownCommand
 	}
 	return cmd, m[2], nil
 }
@@ -401,6 +484,402 @@ func UrlUnFurl(furl string) string {
 
 func RegisterFunc(name string, f CommandFunc) {
 	commandFuncs[name] = f
+	commandModules[name] = callerModule()
+}
+
+// RegisterCommand registers f like RegisterFunc. Messages for it are
+// parsed as described by spec and f gets the result in Message.Args.
+func RegisterCommand(name string, spec Spec, f CommandFunc) {
+	commandFuncs[name] = f
+	commandSpecs[name] = &spec
+	commandModules[name] = callerModule()
+}
+
+// callerModule returns the name of the package that called the
+// function calling callerModule.
+func callerModule() string {
+	pc, _, _, ok := runtime.Caller(2)
+	if !ok {
+		return ""
+	}
+	name := runtime.FuncForPC(pc).Name()
+	if o := strings.LastIndexByte(name, '/'); o != -1 {
+		name = name[o+1:]
+	}
+	if o := strings.IndexByte(name, '.'); o != -1 {
+		name = name[:o]
+	}
+	return name
+}
+
+// RegisteredFuncs returns the sorted names of all registered funcs.
+func RegisteredFuncs() []string {
+	names := make([]string, 0, len(commandFuncs))
+	for name := range commandFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Identify sets the user adi runs as. Messages are only handled when
+// they mention that user or, if shortCommandSign is not empty, start
+// with it.
+func Identify(id, shortCommandSign string) {
+	Lock()
+	defer Unlock()
+	identify(id, shortCommandSign)
+}
+
+func identify(id, shortCommandSign string) {
+	botID = id
+	if shortCommandSign != "" {
+		reToMe = regexp.MustCompile(fmt.Sprintf("^(?:<@%s>\\s*|%s)",
+			id, shortCommandSign))
+	} else {
+		reToMe = regexp.MustCompile(fmt.Sprintf("^<@%s>\\s*", id))
+	}
+}
+
+// HandleMessage runs the command or pipeline in text, if it is
+// addressed to adi, and replies in channel. Each command of a pipeline
+// gets the reply of the one before appended to its text, and the prices
+// of all commands that ran are charged at once.
+func HandleMessage(t Transport, channel, user, text, timestamp string) {
+	RLock()
+	re, id := reToMe, botID
+	RUnlock()
+	if re == nil || user == id {
+		return
+	}
+	recordActivity(channel, user)
+	{
+		m := re.FindStringSubmatch(text)
+		if m == nil {
+			return
+		}
+		text = text[len(m[0]):]
+	}
+	log.Println(user, text)
+	RLock()
+	stages := splitPipeline(text)
+	RUnlock()
+	if len(stages) > maxStages {
+		t.SendMessage(channel, fmt.Sprintf(
+			"a pipeline can have at most %d commands", maxStages))
+		return
+	}
+	// every command of a pipeline is checked before the first one runs
+	var (
+		calls = make([]call, len(stages))
+		total Points
+	)
+	for i, s := range stages {
+		c, err := prepareCommand(channel, user, s)
+		if err != nil {
+			t.SendMessage(channel, err.Error())
+			return
+		}
+		calls[i] = c
+		total += c.price
+	}
+	var cmds []*Command
+	for _, c := range calls {
+		for _, s := range c.steps {
+			cmds = append(cmds, s.cmd)
+		}
+	}
+	if err := throttle(cmds, channel, user, time.Now()); err != nil {
+		t.SendMessage(channel, err.Error())
+		return
+	}
+	u := calls[0].user
+	if len(stages) > 1 {
+		RLock()
+		points := u.Points
+		RUnlock()
+		if total > points {
+			t.SendMessage(channel, fmt.Sprintf(
+				"not enough points. your points: %d. required: %d",
+				points, total))
+			return
+		}
+	}
+	var (
+		r     Response
+		err   error
+		price Points
+		names []string
+		m     = Message{User: u, Channel: channel, Timestamp: timestamp}
+	)
+	for i, c := range calls {
+		if i > 0 {
+			c, err = prepareCommand(channel, user, feed(stages[i], r.Text))
+			if err != nil {
+				r = Response{}
+				break
+			}
+		}
+		var p Points
+		r, p, err = runCall(t, c, m)
+		if r.Charge || p > 0 {
+			price += p
+			names = append(names, c.name)
+		}
+		if err != nil || !r.Charge {
+			break
+		}
+	}
+	if r.Text != "" {
+		if err := t.PostMessage(channel, r); err != nil {
+			log.Println("ERROR:", err)
+		}
+	}
+	if err != nil {
+		t.SendMessage(channel, err.Error())
+	}
+	if len(names) > 0 {
+		spendRate(channel, user, time.Now())
+		Lock()
+		charge(u, price, strings.Join(names, " | "), timestamp)
+		Unlock()
+	}
+}
+
+// runCall runs the steps of c one after another and joins their
+// replies. It returns what c costs, which are only the steps that
+// were charged if not all of them were.
+func runCall(t Transport, c call, m Message) (Response, Points, error) {
+	var (
+		texts  []string
+		price  Points
+		unfurl bool
+	)
+	partial := func(err error) (Response, Points, error) {
+		return Response{Text: strings.Join(texts, "\n")}, price, err
+	}
+	for _, s := range c.steps {
+		args, err := s.cmd.Spec.Parse(t, s.cmd.Name, s.params)
+		if err != nil {
+			return partial(err)
+		}
+		m.Text, m.Args, m.Command = s.params, args, s.cmd.Name
+		r, ok := runCommand(t, m.Channel, s.cmd, m)
+		if !ok {
+			return partial(fmt.Errorf("%s took too long", s.cmd.Name))
+		}
+		if r.Text != "" {
+			texts = append(texts, r.Text)
+		}
+		if !r.Charge {
+			return partial(nil)
+		}
+		startCooldown(s.cmd, m.Channel, m.User.ID, time.Now())
+		price += s.cmd.Price
+		unfurl = unfurl || r.UnfurlLinks
+	}
+	return Response{
+		Text:        strings.Join(texts, "\n"),
+		Charge:      true,
+		UnfurlLinks: unfurl,
+	}, c.price, nil
+}
+
+// runCommand runs cmd until it returns or its time is up and shows
+// that adi is typing while the command takes longer. A command that
+// ran out of time is never charged and can not change the state
+// anymore, unless it already started to with LockChange.
+func runCommand(t Transport, channel string, cmd *Command, m Message) (Response, bool) {
+	timeout := cmd.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var rs *int32
+	m.Context, rs = withRunState(ctx)
+	done := make(chan Response, 1)
+	go func() {
+		done <- cmd.Func(m, t)
+	}()
+	typing := time.NewTimer(typingAfter)
+	defer typing.Stop()
+	for {
+		select {
+		case r := <-done:
+			return r, true
+		case <-typing.C:
+			t.Typing(channel)
+			typing.Reset(typingAfter * 3)
+		case <-ctx.Done():
+			if atomic.CompareAndSwapInt32(rs, running, timedOut) {
+				return Response{}, false
+			}
+			// it is changing the state already, so it has to finish
+			return <-done, true
+		}
+	}
+}
+
+type job struct {
+	channel, user, text, timestamp string
+}
+
+// startWorkers starts n workers handling the messages sent to the
+// returned channel.
+func startWorkers(t Transport, n int) chan<- job {
+	jobs := make(chan job, n*8)
+	for i := 0; i < n; i++ {
+		go func() {
+			for j := range jobs {
+				HandleMessage(t, j.channel, j.user, j.text, j.timestamp)
+			}
+		}()
+	}
+	return jobs
+}
+
+// step is a command and the parameters it runs with.
+type step struct {
+	cmd    *Command
+	params string
+}
+
+// call is a command that was checked to be run by user. A proxy runs
+// the steps it expands to, other commands are their only step.
+type call struct {
+	name  string
+	steps []step
+	// price is what the steps cost together, or the price of the proxy
+	// if that is higher.
+	price Points
+	user  *User
+}
+
+// prepareCommand looks up the command in text and checks if user may
+// run it in channel. Proxies are expanded and everything they run is
+// checked as well.
+func prepareCommand(channel, user, text string) (call, error) {
+	Lock()
+	defer Unlock()
+	u := GetCreateUser(user)
+	cmd, params, err := parseCommand(text)
+	if err == errUnknownCommand {
+		return call{}, unknownCommand(text, u, channel)
+	}
+	if err != nil {
+		return call{}, err
+	}
+	c := call{name: cmd.Name, user: u}
+	c.steps, c.price, err = expandCommand(u, channel, cmd, params, []string{cmd.Name})
+	if err != nil {
+		return call{}, err
+	}
+	if c.price > u.Points {
+		return call{}, fmt.Errorf(
+			"not enough points. your points: %d. required: %d",
+			u.Points, c.price)
+	}
+	return c, nil
+}
+
+// expandCommand checks cmd and returns the steps it runs and what they
+// cost. path are the proxies that led to cmd.
+func expandCommand(u *User, channel string, cmd *Command, params string, path []string) ([]step, Points, error) {
+	if err := mayRun(u, cmd, channel); err != nil {
+		return nil, 0, err
+	}
+	if cmd.Proxy == "" {
+		if cmd.Func == nil {
+			log.Println("ERROR: command has no func:", cmd.Name)
+			return nil, 0, fmt.Errorf("%s is not available", cmd.Name)
+		}
+		return []step{{cmd, params}}, cmd.Price, nil
+	}
+	texts, err := expandTemplate(cmd.Name, cmd.Proxy, params, u.ID, channel)
+	if err != nil {
+		return nil, 0, err
+	}
+	var (
+		steps []step
+		price Points
+	)
+	for _, text := range texts {
+		next, np, err := parseCommand(text)
+		if err == errUnknownCommand {
+			return nil, 0, unknownCommand(text, u, channel)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if next.Proxy != "" {
+			for _, name := range path {
+				if name == next.Name {
+					return nil, 0, fmt.Errorf("%s runs itself: %s", path[0],
+						strings.Join(append(path, next.Name), " -> "))
+				}
+			}
+			if len(path) == maxProxyDepth {
+				return nil, 0, fmt.Errorf("%s nests more than %d proxies",
+					path[0], maxProxyDepth)
+			}
+		}
+		ss, sp, err := expandCommand(u, channel, next, np,
+			append(path[:len(path):len(path)], next.Name))
+		if err != nil {
+			return nil, 0, err
+		}
+		steps = append(steps, ss...)
+		price += sp
+		if len(steps) > maxSteps {
+			return nil, 0, fmt.Errorf("%s runs more than %d commands",
+				path[0], maxSteps)
+		}
+	}
+	if cmd.Price > price {
+		price = cmd.Price
+	}
+	return steps, price, nil
+}
+
+// mayRun returns why u may not run cmd in channel, if so.
+func mayRun(u *User, cmd *Command, channel string) error {
+	if !Enabled(cmd, channel) {
+		return fmt.Errorf("%s is disabled in this channel", cmd.Name)
+	}
+	if !Allowed(u, cmd) {
+		return fmt.Errorf("unprivileged. your level: %d. required: %d",
+			u.Level, cmd.RequiredLevel)
+	}
+	if cmd.Price > u.Points {
+		return fmt.Errorf("not enough points. your points: %d. required: %d",
+			u.Points, cmd.Price)
+	}
+	return nil
+}
+
+// charge moves price from u to the bank for the commands in reason.
+// The user may have spent points while the commands ran, so never more
+// than u has is taken.
+func charge(u *User, price Points, reason, timestamp string) {
+	Transfer(UserAccount(u), BankAccount(), price, reason, timestamp)
+}
+
+func HttpGetWithContext(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req.WithContext(ctx))
+}
+
+func HttpPostWithContext(ctx context.Context, url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return http.DefaultClient.Do(req.WithContext(ctx))
 }
 
 func HttpGetWithTimeout(url string, timeout time.Duration) (*http.Response, error) {
@@ -427,80 +906,109 @@ func Run() {
 		defer f.Close()
 		log.SetOutput(f)
 	}
-	var (
-		debug            bool
-		key              string
-		shortCommands    bool
-		shortCommandSign string
-	)
+	const configPath = "./config.json"
+	config, err := readConfig(configPath)
+	if err != nil {
+		log.Panicln(err)
+	}
+	{
+		applies, err := checkSettings(config)
+		if err != nil {
+			log.Panicln(err)
+		}
+		for _, apply := range applies {
+			apply()
+		}
+	}
+	DefaultLevel = config.DefaultLevel
+	DubtrackRoom = config.DubtrackRoom
+	setRoles(config.Roles)
+	SetRateLimits(config.RateLimits.User, config.RateLimits.Channel)
+	workers := config.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	ledgerPath := config.Ledger
+	if ledgerPath == "" {
+		ledgerPath = "./ledger.jsonl"
+	}
+	auditPath := config.Audit
+	if auditPath == "" {
+		auditPath = "./audit.jsonl"
+	}
+	var rl *reloader
 	{
-		var config struct {
-			Debug            bool   `json:"debug"`
-			Key              string `json:"key"`
-			ShortCommands    bool   `json:"short_commands"`
-			ShortCommandSign string `json:"short_command_sign"`
-			DefaultLevel     Level  `json:"default_level"`
-			DubtrackRoom     string `json:"dubtrack_room"`
-		}
-		fd, err := os.OpenFile("./config.json", os.O_RDONLY, 0750)
+		st, err := openStore(config)
 		if err != nil {
 			log.Panicln(err)
 		}
-		if err := json.NewDecoder(fd).Decode(&config); err != nil {
-			fd.Close()
+		defer st.Close()
+		Commands = make([]*Command, 0, 10)
+		Users = make([]*User, 0, 10)
+		err = st.Load(&Users, &Commands, &GlobalBank, &Rules)
+		if err == ErrEmptyStore {
+			log.Println("store is empty, importing json files")
+			err = NewFileStore(".", 0).Load(&Users, &Commands, &GlobalBank, &Rules)
+		}
+		if err != nil {
 			log.Panicln(err)
 		}
-		fd.Close()
-		debug = config.Debug
-		key = config.Key
-		shortCommands = config.ShortCommands
-		shortCommandSign = config.ShortCommandSign
-		DefaultLevel = config.DefaultLevel
-		DubtrackRoom = config.DubtrackRoom
+		if err := checkCommands(Commands); err != nil {
+			log.Panicln(err)
+		}
+		for _, p := range checkCommandFuncs(Commands) {
+			log.Println("WARNING:", p)
+		}
+		for _, p := range checkOldProxies(Commands) {
+			log.Println("WARNING:", p)
+		}
+		if GlobalBank.Lottery.Tickets == nil {
+			GlobalBank.Lottery.Tickets = map[string]uint64{}
+		}
+		defaultSalary()
+		setOwners(config.Owners)
+		if len(Owners) == 0 {
+			log.Println("WARNING: no owners are set")
+		}
+		SetStore(st)
+		rl = newReloader(configPath, st)
 	}
 	{
-		readDump := func(file string, item interface{}) {
-			fd, err := os.OpenFile(file, os.O_RDONLY, 0750)
-			if err != nil {
-				log.Panicln(err)
-			}
-			if err := json.NewDecoder(fd).Decode(item); err != nil {
-				fd.Close()
-				log.Panicln(err)
-			}
-			fd.Close()
+		l, err := NewFileLedger(ledgerPath)
+		if err != nil {
+			log.Panicln(err)
+		}
+		if err := SetLedger(l); err != nil {
+			log.Panicln(err)
+		}
+		RLock()
+		diffs, err := AuditLedger()
+		RUnlock()
+		if err != nil {
+			log.Panicln(err)
+		}
+		for _, d := range diffs {
+			log.Println("WARNING: ledger:", d)
 		}
-		Commands = make([]Command, 0, 10)
-		readDump("./commands.json", &Commands)
-		Users = make([]User, 0, 10)
-		readDump("./users.json", &Users)
-		readDump("./bank.json", &GlobalBank)
 	}
 	{
-		visibleCmds := make([]string, 0, len(Commands))
-		commandStrings := make([]string, len(Commands))
-		for i, _ := range Commands {
-			name := Commands[i].Name
-			f, ok := commandFuncs[name]
-			if ok {
-				Commands[i].Func = f
-			}
-			commandStrings[i] = name
-			if Commands[i].Visible {
-				visibleCmds = append(visibleCmds, name)
-			}
+		l, err := NewFileAuditLog(auditPath)
+		if err != nil {
+			log.Panicln(err)
 		}
-		sort.Sort(sort.StringSlice(visibleCmds))
-		helpString = strings.Join(visibleCmds, ", ")
-		reCommand = regexp.MustCompile(
-			fmt.Sprintf("(?s)^(%s)(?:\\s+(.+))?\\s*$",
-				strings.Join(commandStrings, "|")))
+		SetAuditLog(l)
 	}
-	var reToMe *regexp.Regexp
-	var bot *slack.User
+	ResetCommands()
 	tick := time.NewTicker(time.Minute)
-	api := slack.New(key, slack.OptionDebug(debug))
+	resync := time.NewTicker(directoryResync)
+	watch := time.NewTicker(watchEvery)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	api := slack.New(config.Key, slack.OptionDebug(config.Debug))
 	rtm := api.NewRTM()
+	st := &slackTransport{rtm: rtm}
+	var t Transport = st
+	jobs := startWorkers(t, workers)
 	go rtm.ManageConnection()
 Loop:
 	for {
@@ -513,85 +1021,37 @@ Loop:
 				if err != nil {
 					log.Fatal(err)
 				}
-				bot = u
-				if shortCommands {
-					reToMe = regexp.MustCompile(fmt.Sprintf("^(?:<@%s>\\s*|%s)",
-						rtm.GetInfo().User.ID,
-						shortCommandSign))
+				st.bot = u
+				syncDirectory(rtm, t)
+				if config.ShortCommands {
+					Identify(u.ID, config.ShortCommandSign)
 				} else {
-					reToMe = regexp.MustCompile(fmt.Sprintf("^<@%s>\\s*",
-						rtm.GetInfo().User.ID))
+					Identify(u.ID, "")
 				}
 			case *slack.MessageEvent:
-				if ev.User == bot.ID {
-					continue Loop
-				}
-				{
-					m := reToMe.FindStringSubmatch(ev.Text)
-					if m == nil {
-						continue Loop
-					}
-					ev.Text = ev.Text[len(m[0]):]
-				}
-				log.Println(ev.User, ev.Text)
-				cmd, params, err := parseCommand(ev.Text)
-				if err != nil {
-					rtm.SendMessage(rtm.NewOutgoingMessage(
-						err.Error(), ev.Channel))
-					continue Loop
-				}
-				u := GetCreateUser(ev.User)
-				if u.Level < cmd.RequiredLevel {
-					rtm.SendMessage(rtm.NewOutgoingMessage(
-						fmt.Sprintf(
-							"unprivileged. your level: %d. required: %d",
-							u.Level, cmd.RequiredLevel), ev.Channel))
-					continue Loop
+				select {
+				case jobs <- job{ev.Channel, ev.User, ev.Text, ev.Timestamp}:
+				default:
+					log.Println("ERROR: too busy, dropped message", ev.User, ev.Text)
 				}
-				if cmd.Price > u.Points {
-					rtm.SendMessage(rtm.NewOutgoingMessage(
-						fmt.Sprintf(
-							"not enough points. your points: %d. required: %d",
-							u.Points, cmd.Price), ev.Channel))
-					continue Loop
-				}
-				var r Response
-				if cmd.Proxy != "" {
-					var nc string
-					if strings.Contains(cmd.Proxy, "%s") {
-						nc = fmt.Sprintf(cmd.Proxy, params)
-					} else {
-						nc = cmd.Proxy
-					}
-					cmd, params, err = parseCommand(nc)
-					if err != nil {
-						rtm.SendMessage(rtm.NewOutgoingMessage(
-							err.Error(), ev.Channel))
-						continue Loop
-					}
-				}
-				r = cmd.Func(Message{
-					Text:      params,
-					User:      u,
-					Timestamp: ev.Timestamp,
-				}, rtm)
-				if r.Text != "" {
-					rtm.PostMessage(ev.Channel,
-						slack.MsgOptionText(r.Text, false),
-						slack.MsgOptionPostMessageParameters(slack.PostMessageParameters{
-							Parse:       "full",
-							UnfurlLinks: r.UnfurlLinks,
-							UnfurlMedia: r.UnfurlLinks,
-							AsUser:      true,
-							Username:    bot.Name,
-							IconURL:     bot.Profile.ImageOriginal,
-						}))
+			case *slack.TeamJoinEvent:
+				PutUser(toChatUser(&ev.User))
+			case *slack.UserChangeEvent:
+				PutUser(toChatUser(&ev.User))
+			case *slack.ChannelCreatedEvent:
+				PutChannel(Channel{ID: ev.Channel.ID, Name: ev.Channel.Name})
+			case *slack.ChannelRenameEvent:
+				PutChannel(Channel{ID: ev.Channel.ID, Name: ev.Channel.Name})
+			case *slack.GroupJoinedEvent:
+				PutChannel(Channel{ID: ev.Channel.ID, Name: ev.Channel.Name,
+					Private: true})
+			case *slack.PresenceChangeEvent:
+				if ev.User != "" {
+					SetPresence(ev.User, ev.Presence)
 				}
-				if cmd.Price > 0 && r.Charge {
-					u.Points.Sub(cmd.Price)
-					GlobalBank.Points.Add(cmd.Price)
+				for _, u := range ev.Users {
+					SetPresence(u, ev.Presence)
 				}
-			case *slack.PresenceChangeEvent:
 			case *slack.LatencyReport:
 			case *slack.RTMError:
 				log.Printf("Error: %s\n", ev.Error())
@@ -601,39 +1061,19 @@ Loop:
 			default:
 			}
 		case <-tick.C:
-			{
-				drawLottery(rtm)
-				{ // salary
-					us, _ := rtm.GetUsers()
-					for _, o := range us {
-						if GlobalBank.Points == 0 ||
-							o.IsBot ||
-							o.ID == "USLACKBOT" ||
-							o.Presence != "active" {
-							continue
-						}
-						uo := GetCreateUser(o.ID)
-						GlobalBank.Points.Sub(1)
-						uo.Points.Add(1)
-					}
-				}
-				writeDump := func(file string, item interface{}) {
-					fd, err := os.OpenFile(file,
-						os.O_WRONLY|os.O_TRUNC, 0750)
-					if err != nil {
-						log.Println(err)
-						return
-					}
-					if err := json.NewEncoder(fd).Encode(item); err != nil {
-						fd.Close()
-						log.Println(err)
-						return
-					}
-					fd.Close()
+			Tick(t, time.Now().UTC())
+		case <-resync.C:
+			syncDirectory(rtm, t)
+		case <-watch.C:
+			if rl.changed() {
+				if err := rl.reload(); err != nil {
+					log.Println("ERROR: reload:", err)
 				}
-				writeDump("./users.json", Users)
-				writeDump("./commands.json", Commands)
-				writeDump("./bank.json", GlobalBank)
+			}
+		case <-hup:
+			rl.changed()
+			if err := rl.reload(); err != nil {
+				log.Println("ERROR: reload:", err)
 			}
 		}
 	}
@@ -0,0 +1,124 @@
+// Package aditest runs adi commands against a fake workspace so they
+// can be tested without slack.
+package aditest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/henkman/slackbot/adi"
+	"github.com/henkman/slackbot/adi/fake"
+)
+
+const (
+	BotID   = "UADI"
+	Channel = "CGENERAL"
+)
+
+type Workspace struct {
+	Transport *fake.Transport
+	Bot       adi.ChatUser
+	ts        int64
+}
+
+// New resets the state of adi and returns a workspace with the bot and
+// a "general" channel. Every registered func is available as visible
+// command without price, required level and cooldown.
+func New() *Workspace {
+	w := &Workspace{
+		Transport: fake.New(),
+		Bot: adi.ChatUser{
+			ID:    BotID,
+			Name:  "adi",
+			IsBot: true,
+		},
+		ts: 1500000000,
+	}
+	w.Transport.AddUser(w.Bot)
+	w.Transport.AddChannel(adi.Channel{ID: Channel, Name: "general"})
+	adi.DefaultLevel = 0
+	adi.Users = make([]*adi.User, 0, 10)
+	adi.GlobalBank = adi.Bank{}
+	adi.GlobalBank.Lottery.Tickets = map[string]uint64{}
+	adi.Commands = make([]*adi.Command, 0, 10)
+	adi.Rules = nil
+	adi.Roles = map[string][]string{}
+	adi.Owners = nil
+	adi.ResetCommands()
+	for _, c := range adi.Commands {
+		c.Price = 0
+		c.RequiredLevel = 0
+		c.Cooldown = adi.Cooldown{}
+		c.Visible = true
+	}
+	adi.SetLedger(&adi.MemoryLedger{})
+	adi.SetAuditLog(&adi.MemoryAuditLog{})
+	adi.SyncDirectory(w.Transport)
+	adi.Identify(w.Bot.ID, "")
+	return w
+}
+
+// AddUser adds an active user to the workspace and to adi.
+func (w *Workspace) AddUser(id, name string, level adi.Level, points adi.Points) {
+	w.Transport.AddUser(adi.ChatUser{
+		ID:       id,
+		Name:     name,
+		Presence: "active",
+	})
+	adi.SyncDirectory(w.Transport)
+	adi.Lock()
+	defer adi.Unlock()
+	u := adi.GetCreateUser(id)
+	u.Level = level
+	adi.Deposit(adi.UserAccount(u), points, "aditest")
+}
+
+// SetPresence changes the presence of a user as if adi got an event.
+func (w *Workspace) SetPresence(id, presence string) {
+	w.Transport.SetPresence(id, presence)
+	adi.SetPresence(id, presence)
+}
+
+// Tick runs the periodic work of adi as if it was now.
+func (w *Workspace) Tick(now time.Time) {
+	adi.Tick(w.Transport, now)
+}
+
+func (w *Workspace) AddChannel(id, name string) {
+	w.Transport.AddChannel(adi.Channel{ID: id, Name: name})
+	adi.SyncDirectory(w.Transport)
+}
+
+// Send passes text from user in channel through adi as is and returns
+// all messages sent in response.
+func (w *Workspace) Send(user, channel, text string) []fake.Message {
+	before := len(w.Transport.Messages())
+	w.ts++
+	adi.HandleMessage(w.Transport, channel, user, text,
+		fmt.Sprintf("%d.000100", w.ts))
+	return w.Transport.Messages()[before:]
+}
+
+// Say addresses text from user to the bot in the general channel and
+// returns the texts of all replies joined by newlines.
+func (w *Workspace) Say(user, text string) string {
+	return w.SayIn(user, Channel, text)
+}
+
+// SayIn is like Say in another channel.
+func (w *Workspace) SayIn(user, channel, text string) string {
+	ms := w.Send(user, channel, fmt.Sprintf("<@%s> %s", w.Bot.ID, text))
+	ts := make([]string, len(ms))
+	for i, m := range ms {
+		ts[i] = m.Text
+	}
+	return strings.Join(ts, "\n")
+}
+
+// User returns a copy of the adi user with id.
+func (w *Workspace) User(id string) adi.User {
+	adi.Lock()
+	defer adi.Unlock()
+	return *adi.GetCreateUser(id)
+}
@@ -0,0 +1,284 @@
+package aditest_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/henkman/slackbot/adi"
+	"github.com/henkman/slackbot/adi/aditest"
+)
+
+func init() {
+	adi.RegisterFunc("fast", func(m adi.Message, t adi.Transport) adi.Response {
+		return adi.Response{
+			Text:   "done",
+			Charge: true,
+		}
+	})
+	adi.RegisterFunc("upper", func(m adi.Message, t adi.Transport) adi.Response {
+		return adi.Response{
+			Text:   strings.ToUpper(m.Text),
+			Charge: true,
+		}
+	})
+	adi.RegisterFunc("fail", func(m adi.Message, t adi.Transport) adi.Response {
+		return adi.Response{
+			Text: "failed",
+		}
+	})
+	adi.RegisterFunc("slow", func(m adi.Message, t adi.Transport) adi.Response {
+		<-m.Context.Done()
+		return adi.Response{
+			Text:   "done",
+			Charge: true,
+		}
+	})
+	adi.RegisterFunc("slowgive", func(m adi.Message, t adi.Transport) adi.Response {
+		defer func() { slowgiveDone <- true }()
+		<-slowgiveGo
+		if !adi.LockChange(m) {
+			return adi.Response{}
+		}
+		defer adi.Unlock()
+		adi.Deposit(adi.UserAccount(m.User), 5, "slowgive")
+		return adi.Response{
+			Text:   "gave 5",
+			Charge: true,
+		}
+	})
+	adi.RegisterFunc("givesl", func(m adi.Message, t adi.Transport) adi.Response {
+		if !adi.LockChange(m) {
+			return adi.Response{}
+		}
+		defer adi.Unlock()
+		<-m.Context.Done()
+		adi.Deposit(adi.UserAccount(m.User), 5, "givesl")
+		return adi.Response{
+			Text:   "gave 5",
+			Charge: true,
+		}
+	})
+}
+
+// slowgive goes on once slowgiveGo gets something and sends to
+// slowgiveDone when it returned.
+var (
+	slowgiveGo   = make(chan bool)
+	slowgiveDone = make(chan bool, 1)
+)
+
+func TestTimeout(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 10)
+	cmd := adi.GetCommandByName("slow")
+	cmd.Price = 5
+	cmd.Timeout = time.Millisecond * 10
+	if r := w.Say("UALICE", "slow"); r != "slow took too long" {
+		t.Errorf("unexpected reply %q", r)
+	}
+	if p := w.User("UALICE").Points; p != 10 {
+		t.Errorf("expected no charge for timed out command, has %d", p)
+	}
+}
+
+func TestTimeoutChange(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 10)
+	for _, name := range []string{"slowgive", "givesl"} {
+		adi.GetCommandByName(name).Timeout = time.Millisecond * 10
+	}
+	// too late to change anything
+	if r := w.Say("UALICE", "slowgive"); r != "slowgive took too long" {
+		t.Errorf("unexpected reply %q", r)
+	}
+	slowgiveGo <- true
+	<-slowgiveDone
+	if p := w.User("UALICE").Points; p != 10 {
+		t.Errorf("expected timed out command to change nothing, has %d", p)
+	}
+	// started changing in time, so it is waited for
+	if r := w.Say("UALICE", "givesl"); r != "gave 5" {
+		t.Errorf("unexpected reply %q", r)
+	}
+	if p := w.User("UALICE").Points; p != 15 {
+		t.Errorf("expected command that started changing to finish, has %d", p)
+	}
+}
+
+func TestCommandWithoutFunc(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 10)
+	adi.Lock()
+	adi.Commands = append(adi.Commands, &adi.Command{Name: "gone", Visible: true})
+	adi.ResetCommands()
+	adi.Unlock()
+	if r := w.Say("UALICE", "gone"); r != "gone is not available" {
+		t.Errorf("unexpected reply %q", r)
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 10)
+	w.AddUser("UBOB", "bob", 0, 10)
+	cmd := adi.GetCommandByName("fast")
+	cmd.Price = 1
+	cmd.Cooldown.User = time.Minute
+	tests := []struct {
+		user   string
+		reply  string
+		points adi.Points
+	}{
+		{"UALICE", "done", 9},
+		{"UALICE", "fast is cooling down. try again in 60s", 9},
+		{"UBOB", "done", 9},
+	}
+	for _, test := range tests {
+		if r := w.Say(test.user, "fast"); r != test.reply {
+			t.Errorf("%s: expected reply %q, got %q", test.user, test.reply, r)
+		}
+		if p := w.User(test.user).Points; p != test.points {
+			t.Errorf("%s: expected %d points, got %d", test.user, test.points, p)
+		}
+	}
+}
+
+func TestCooldownNotCharged(t *testing.T) {
+	// limits are not reset by New, so this is a user of its own
+	w := aditest.New()
+	w.AddUser("UDAVE", "dave", 0, 10)
+	adi.GetCommandByName("fail").Cooldown.User = time.Minute
+	adi.GetCommandByName("fast").Cooldown.User = time.Minute
+	adi.GetCommandByName("upper").Price = 1
+	tests := []struct {
+		text   string
+		reply  string
+		points adi.Points
+	}{
+		// refused runs are not charged and do not cool down
+		{"fail", "failed", 10},
+		{"fail", "failed", 10},
+		// nothing runs if a later step would be cooling down
+		{"upper a | fast | fast", "fast can only run once as it cools down for 1m0s per user", 10},
+		{"fast", "done", 10},
+		{"fast", "fast is cooling down. try again in 60s", 10},
+	}
+	for _, test := range tests {
+		if r := w.Say("UDAVE", test.text); r != test.reply {
+			t.Errorf("%q: expected reply %q, got %q", test.text, test.reply, r)
+		}
+		if p := w.User("UDAVE").Points; p != test.points {
+			t.Errorf("%q: expected %d points, got %d", test.text, test.points, p)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 10)
+	adi.SetRateLimits(adi.RateLimit{PerMinute: 1, Burst: 2}, adi.RateLimit{})
+	defer adi.SetRateLimits(adi.RateLimit{}, adi.RateLimit{})
+	adi.GetCommandByName("fast").Price = 1
+	replies := []string{"done", "done", "slow down. try again in 60s"}
+	for _, reply := range replies {
+		if r := w.Say("UALICE", "fast"); r != reply {
+			t.Errorf("expected reply %q, got %q", reply, r)
+		}
+	}
+	if p := w.User("UALICE").Points; p != 8 {
+		t.Errorf("expected to be charged twice, has %d points", p)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 0)
+	adi.GetCommandByName("fast").Price = 1
+	adi.GetCommandByName("upper").Price = 2
+	tests := []struct {
+		text   string
+		reply  string
+		before adi.Points
+		after  adi.Points
+		reason string
+	}{
+		{"fast | upper", "DONE", 10, 7, "fast | upper"},
+		{"upper a | upper b", "B A", 10, 6, "upper | upper"},
+		{"upper x | fail | upper", "failed", 10, 8, "upper"},
+		{"upper a || b", "A || B", 10, 8, "upper"},
+		{`upper "a | upper"`, `"A | UPPER"`, 10, 8, "upper"},
+		{"upper a | nope", "A | NOPE", 10, 8, "upper"},
+		{"fast | upper", "not enough points. your points: 2. required: 3", 2, 2, ""},
+		{"fast | fast | fast | fast | fast | fast",
+			"a pipeline can have at most 5 commands", 10, 10, ""},
+	}
+	for _, test := range tests {
+		adi.Lock()
+		adi.GetCreateUser("UALICE").Points = test.before
+		adi.Unlock()
+		if r := w.Say("UALICE", test.text); r != test.reply {
+			t.Errorf("%q: expected reply %q, got %q", test.text, test.reply, r)
+		}
+		if p := w.User("UALICE").Points; p != test.after {
+			t.Errorf("%q: expected %d points, got %d", test.text, test.after, p)
+		}
+		if test.reason == "" {
+			continue
+		}
+		adi.RLock()
+		txs, err := adi.History("UALICE", 1)
+		adi.RUnlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(txs) != 1 || txs[0].Reason != test.reason {
+			t.Errorf("%q: expected a charge for %q, got %v", test.text, test.reason, txs)
+		}
+	}
+}
+
+func TestProxyExpansion(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 10)
+	adi.GetCommandByName("upper").Price = 2
+	proxies := map[string]string{
+		"shout": "upper $@",
+		"twice": "shout $1 $1",
+		"both":  "upper one; twice two",
+		"mixed": "upper a; fail; upper b",
+		"ping":  "pong",
+		"pong":  "ping",
+	}
+	for i := 0; i < 9; i++ {
+		proxies[fmt.Sprintf("d%d", i)] = fmt.Sprintf("d%d", i+1)
+	}
+	proxies["d9"] = "upper deep"
+	adi.Lock()
+	for name, proxy := range proxies {
+		adi.Commands = append(adi.Commands, &adi.Command{Name: name, Proxy: proxy})
+	}
+	adi.ResetCommands()
+	adi.Unlock()
+	tests := []struct {
+		text   string
+		reply  string
+		points adi.Points
+	}{
+		{"twice hey", "HEY HEY", 8},
+		{"both", "ONE\nTWO TWO", 4},
+		{"mixed", "A\nfailed", 2},
+		{"ping", "ping runs itself: ping -> pong -> ping", 2},
+		{"d2", "DEEP", 0},
+		{"d1", "d1 nests more than 8 proxies", 0},
+	}
+	for _, test := range tests {
+		if r := w.Say("UALICE", test.text); r != test.reply {
+			t.Errorf("%q: expected reply %q, got %q", test.text, test.reply, r)
+		}
+		if p := w.User("UALICE").Points; p != test.points {
+			t.Errorf("%q: expected %d points, got %d", test.text, test.points, p)
+		}
+	}
+}
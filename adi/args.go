@@ -0,0 +1,355 @@
+package adi
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ArgType is the kind of value an argument takes.
+type ArgType uint8
+
+const (
+	// ArgWord is a single word or a quoted text.
+	ArgWord ArgType = iota
+	// ArgText is the rest of the message as it was written. It has to
+	// be the last argument.
+	ArgText
+	// ArgInt is an integer, limited to Min and Max if Max is not 0.
+	ArgInt
+	// ArgPoints is a positive amount of points or "all".
+	ArgPoints
+	// ArgUser is a user as described in ResolveUser.
+	ArgUser
+	// ArgAccount is a user, "bank" or "pot".
+	ArgAccount
+	// ArgChannel is a channel as described in ResolveChannel.
+	ArgChannel
+	// ArgCommand is the name of a command.
+	ArgCommand
+)
+
+type Arg struct {
+	Name     string
+	Type     ArgType
+	Optional bool
+	Min, Max int64
+}
+
+// Flag is an option given as --name or, if it has a Value, as
+// --name=value anywhere before a text argument.
+type Flag struct {
+	Name  string
+	Value string
+}
+
+// Spec describes what a command does and which arguments it takes.
+// Messages for commands with a spec are parsed before the command runs,
+// so the command only gets valid arguments. Price, RequiredLevel,
+// Hidden, Timeout and Cooldown are the defaults of the command until
+// they are changed in commands.json. Users with a role granting Action
+// may run the command whatever their level.
+type Spec struct {
+	Description   string
+	Examples      []string
+	Args          []Arg
+	Flags         []Flag
+	Price         Points
+	RequiredLevel Level
+	Hidden        bool
+	Timeout       time.Duration
+	Cooldown      Cooldown
+	Action        string
+}
+
+// Args are the arguments of a message parsed as described by a Spec.
+type Args struct {
+	values map[string]interface{}
+	flags  map[string]string
+}
+
+// Has reports whether the argument name was given.
+func (a Args) Has(name string) bool {
+	_, ok := a.values[name]
+	return ok
+}
+
+// String returns the argument name as text. Users and channels are
+// returned by their name.
+func (a Args) String(name string) string {
+	switch v := a.values[name].(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case *ChatUser:
+		return v.Name
+	case *Channel:
+		return v.Name
+	}
+	return ""
+}
+
+func (a Args) Int(name string) int64 {
+	n, _ := a.values[name].(int64)
+	return n
+}
+
+func (a Args) User(name string) *ChatUser {
+	u, _ := a.values[name].(*ChatUser)
+	return u
+}
+
+func (a Args) Channel(name string) *Channel {
+	c, _ := a.values[name].(*Channel)
+	return c
+}
+
+// Account returns the account of the argument name. The state has to
+// be locked.
+func (a Args) Account(name string) Account {
+	switch v := a.values[name].(type) {
+	case string:
+		return accountByID(v)
+	case *ChatUser:
+		return UserAccount(GetCreateUser(v.ID))
+	}
+	return nil
+}
+
+// Flag returns the value of the flag name and whether it was given.
+func (a Args) Flag(name string) (string, bool) {
+	v, ok := a.flags[name]
+	return v, ok
+}
+
+// Usage returns the syntax of the command name, like
+// "givepts <user> <points|all>".
+func (s *Spec) Usage(name string) string {
+	parts := []string{name}
+	if s != nil {
+		for _, f := range s.Flags {
+			if f.Value != "" {
+				parts = append(parts, fmt.Sprintf("[--%s=%s]", f.Name, f.Value))
+			} else {
+				parts = append(parts, fmt.Sprintf("[--%s]", f.Name))
+			}
+		}
+		for _, a := range s.Args {
+			n := a.Name
+			switch a.Type {
+			case ArgPoints:
+				n += "|all"
+			case ArgText:
+				n += "..."
+			}
+			if a.Optional {
+				parts = append(parts, "["+n+"]")
+			} else {
+				parts = append(parts, "<"+n+">")
+			}
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Parse parses text as arguments of the command name. Optional
+// arguments that do not fit are skipped. The error is meant to be shown
+// to the user.
+func (s *Spec) Parse(t Transport, name, text string) (Args, error) {
+	a := Args{
+		values: map[string]interface{}{},
+		flags:  map[string]string{},
+	}
+	if s == nil {
+		return a, nil
+	}
+	syntax := errors.New("syntax: " + s.Usage(name))
+	toks := splitArgs(text)
+	var skipped error
+	i := 0
+	for _, arg := range s.Args {
+		for i < len(toks) && s.isFlag(toks[i]) {
+			if err := s.parseFlag(&a, toks[i]); err != nil {
+				return a, err
+			}
+			i++
+		}
+		if i == len(toks) {
+			if arg.Optional {
+				continue
+			}
+			if skipped != nil {
+				return a, skipped
+			}
+			if len(toks) == 0 && s.Description != "" {
+				return a, errors.New(s.Description + "\n" + syntax.Error())
+			}
+			return a, syntax
+		}
+		if arg.Type == ArgText {
+			a.values[arg.Name] = strings.TrimSpace(text[toks[i].pos:])
+			i = len(toks)
+			break
+		}
+		v, err := arg.parse(t, toks[i].text)
+		if err != nil {
+			if _, ok := err.(badValue); arg.Optional && !ok {
+				if skipped == nil {
+					skipped = err
+				}
+				continue
+			}
+			return a, err
+		}
+		a.values[arg.Name] = v
+		i++
+	}
+	for ; i < len(toks); i++ {
+		if !s.isFlag(toks[i]) {
+			if skipped != nil {
+				return a, skipped
+			}
+			return a, syntax
+		}
+		if err := s.parseFlag(&a, toks[i]); err != nil {
+			return a, err
+		}
+	}
+	return a, nil
+}
+
+func (s *Spec) isFlag(tok argToken) bool {
+	return len(s.Flags) > 0 && !tok.quoted && strings.HasPrefix(tok.text, "--")
+}
+
+func (s *Spec) parseFlag(a *Args, tok argToken) error {
+	name, value := tok.text[2:], ""
+	if o := strings.IndexByte(name, '='); o != -1 {
+		name, value = name[:o], name[o+1:]
+	}
+	for _, f := range s.Flags {
+		if f.Name != name {
+			continue
+		}
+		if f.Value != "" && value == "" {
+			return fmt.Errorf("--%s needs a value: --%s=%s", name, name, f.Value)
+		}
+		if f.Value == "" && value != "" {
+			return fmt.Errorf("--%s takes no value", name)
+		}
+		a.flags[name] = value
+		return nil
+	}
+	return fmt.Errorf("unknown flag --%s", name)
+}
+
+// badValue is returned for text that has the form of the argument but
+// an invalid value. Such text is not tried for the next argument.
+type badValue struct {
+	error
+}
+
+func (arg *Arg) parse(t Transport, text string) (interface{}, error) {
+	switch arg.Type {
+	case ArgInt:
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s has to be a number", arg.Name)
+		}
+		if arg.Max != 0 && (n < arg.Min || n > arg.Max) {
+			return nil, badValue{fmt.Errorf("%s has to be between %d and %d",
+				arg.Name, arg.Min, arg.Max)}
+		}
+		return n, nil
+	case ArgPoints:
+		if text == "all" {
+			return text, nil
+		}
+		if n, err := strconv.ParseUint(text, 10, 64); err != nil || n == 0 {
+			return nil, badValue{errors.New("points have to be positive")}
+		}
+		return text, nil
+	case ArgUser:
+		if u := ResolveUser(t, text); u != nil {
+			return u, nil
+		}
+		return nil, errors.New("user not found")
+	case ArgAccount:
+		if text == "bank" || text == "pot" {
+			return text, nil
+		}
+		if u := ResolveUser(t, text); u != nil {
+			return u, nil
+		}
+		return nil, errors.New("user not found")
+	case ArgChannel:
+		if c := ResolveChannel(t, text); c != nil {
+			return c, nil
+		}
+		return nil, errors.New("channel not found")
+	case ArgCommand:
+		RLock()
+		cmd := GetCommandByName(text)
+		RUnlock()
+		if cmd == nil {
+			return nil, errors.New("command not found")
+		}
+		return cmd.Name, nil
+	}
+	return text, nil
+}
+
+type argToken struct {
+	text   string
+	pos    int
+	quoted bool
+}
+
+// splitArgs splits text at white space. Text in double quotes and
+// slack mentions like <@U123|some name> are kept together.
+func splitArgs(text string) []argToken {
+	var toks []argToken
+	i := 0
+	for i < len(text) {
+		r, n := utf8.DecodeRuneInString(text[i:])
+		if unicode.IsSpace(r) {
+			i += n
+			continue
+		}
+		switch r {
+		case '"', '“':
+			start := i
+			i += n
+			end := strings.IndexAny(text[i:], "\"”")
+			if end == -1 {
+				toks = append(toks, argToken{text[i:], start, true})
+				return toks
+			}
+			toks = append(toks, argToken{text[i : i+end], start, true})
+			_, n = utf8.DecodeRuneInString(text[i+end:])
+			i += end + n
+			continue
+		case '<':
+			if end := strings.IndexByte(text[i:], '>'); end != -1 {
+				toks = append(toks, argToken{text[i : i+end+1], i, false})
+				i += end + 1
+				continue
+			}
+		}
+		start := i
+		for i < len(text) {
+			r, n := utf8.DecodeRuneInString(text[i:])
+			if unicode.IsSpace(r) {
+				break
+			}
+			i += n
+		}
+		toks = append(toks, argToken{text[start:i], start, false})
+	}
+	return toks
+}
@@ -0,0 +1,72 @@
+package adi_test
+
+import (
+	"testing"
+
+	"github.com/henkman/slackbot/adi"
+	"github.com/henkman/slackbot/adi/fake"
+)
+
+func TestParseArgs(t *testing.T) {
+	ft := fake.New()
+	ft.AddUser(adi.ChatUser{ID: "U1", Name: "alice"})
+	ft.AddChannel(adi.Channel{ID: "C1", Name: "general"})
+	if err := adi.SyncDirectory(ft); err != nil {
+		t.Fatal(err)
+	}
+	spec := adi.Spec{
+		Description: "does things",
+		Args: []adi.Arg{
+			{Name: "user", Type: adi.ArgUser, Optional: true},
+			{Name: "n", Type: adi.ArgInt, Optional: true, Min: 1, Max: 9},
+			{Name: "where", Type: adi.ArgChannel},
+			{Name: "text", Type: adi.ArgText, Optional: true},
+		},
+		Flags: []adi.Flag{
+			{Name: "loud"},
+			{Name: "as", Value: "name"},
+		},
+	}
+	if u := spec.Usage("do"); u != "do [--loud] [--as=name] [user] [n] <where> [text...]" {
+		t.Errorf("unexpected usage %q", u)
+	}
+	tests := []struct {
+		text  string
+		err   string
+		user  string
+		n     int64
+		where string
+		rest  string
+		as    string
+	}{
+		{"", "does things\nsyntax: do [--loud] [--as=name] [user] [n] <where> [text...]", "", 0, "", "", ""},
+		{"general", "", "", 0, "general", "", ""},
+		{"<@U1>  3 #general", "", "alice", 3, "general", "", ""},
+		{"2 <#C1|general> hello   \"world\"", "", "", 2, "general", "hello   \"world\"", ""},
+		{"--as=bob \"<@U1|x>\" general hi --loud", "", "alice", 0, "general", "hi --loud", "bob"},
+		{"10 general", "n has to be between 1 and 9", "", 0, "", "", ""},
+		{"alice nowhere", "channel not found", "", 0, "", "", ""},
+		{"--as general", "--as needs a value: --as=name", "", 0, "", "", ""},
+		{"--quiet general", "unknown flag --quiet", "", 0, "", "", ""},
+	}
+	for _, test := range tests {
+		a, err := spec.Parse(ft, "do", test.text)
+		if err != nil {
+			if err.Error() != test.err {
+				t.Errorf("%q: expected error %q, got %q", test.text, test.err, err)
+			}
+			continue
+		}
+		if test.err != "" {
+			t.Errorf("%q: expected error %q", test.text, test.err)
+			continue
+		}
+		as, _ := a.Flag("as")
+		if a.String("user") != test.user || a.Int("n") != test.n ||
+			a.String("where") != test.where || a.String("text") != test.rest ||
+			as != test.as {
+			t.Errorf("%q: unexpected args %q %d %q %q %q", test.text,
+				a.String("user"), a.Int("n"), a.String("where"), a.String("text"), as)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package adi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// AuditEntry records a change an admin made with a command. Target is
+// what was changed, like a user id or the name of a command, and
+// Before and After are its values.
+type AuditEntry struct {
+	ID      uint64    `json:"id"`
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Channel string    `json:"channel"`
+	Command string    `json:"command"`
+	Target  string    `json:"target"`
+	Before  string    `json:"before,omitempty"`
+	After   string    `json:"after,omitempty"`
+}
+
+// String returns e like "#3 17.Oct 12:00 UTC alice setlvl bob: 1 -> 3
+// (#general)" with the names of users and channels.
+func (e AuditEntry) String() string {
+	targets := strings.Split(e.Target, " -> ")
+	for i, t := range targets {
+		targets[i] = auditName(t)
+	}
+	return fmt.Sprintf("#%d %s %s %s %s: %s -> %s (#%s)",
+		e.ID, e.Time.Format("02.Jan 15:04 MST"), NameOf(e.User), e.Command,
+		strings.Join(targets, " -> "), auditValue(e.Before), auditValue(e.After),
+		channelNameOf(e.Channel))
+}
+
+// auditName returns the name of the user or channel with id or id
+// itself, which then is the name of a command or account.
+func auditName(id string) string {
+	if u := ChatUserByID(id); u != nil {
+		return u.Name
+	}
+	return channelNameOf(id)
+}
+
+func auditValue(v string) string {
+	if v == "" {
+		return "(none)"
+	}
+	return v
+}
+
+// AuditLog records administrative changes. Entries can only be
+// appended, never changed.
+type AuditLog interface {
+	// Append sets the ID of e and records it.
+	Append(e *AuditEntry) error
+	// Each calls f for every entry from the oldest to the newest until
+	// f returns false.
+	Each(f func(e AuditEntry) bool) error
+}
+
+var auditLog AuditLog
+
+// SetAuditLog makes adi record administrative changes in l.
+func SetAuditLog(l AuditLog) {
+	Lock()
+	defer Unlock()
+	auditLog = l
+}
+
+// Audit records that the command of m changed target from before to
+// after. The state has to be locked.
+func Audit(m Message, target, before, after string) {
+	if auditLog == nil {
+		return
+	}
+	e := AuditEntry{
+		Time:    time.Now().UTC(),
+		Command: m.Command,
+		Channel: m.Channel,
+		Target:  target,
+		Before:  before,
+		After:   after,
+	}
+	if m.User != nil {
+		e.User = m.User.ID
+	}
+	if err := auditLog.Append(&e); err != nil {
+		log.Println("ERROR: audit:", err)
+	}
+}
+
+// AuditEntries returns the last n entries for which match returns true,
+// the newest first. The state has to be locked.
+func AuditEntries(match func(e AuditEntry) bool, n int) ([]AuditEntry, error) {
+	if auditLog == nil {
+		return nil, nil
+	}
+	vs, err := lastN(n, func(keep func(v interface{})) error {
+		return auditLog.Each(func(e AuditEntry) bool {
+			if match(e) {
+				keep(e)
+			}
+			return true
+		})
+	})
+	es := make([]AuditEntry, len(vs))
+	for i, v := range vs {
+		es[i] = v.(AuditEntry)
+	}
+	return es, err
+}
+
+// FileAuditLog appends entries as JSON lines to a file.
+type FileAuditLog struct {
+	lines *jsonLines
+}
+
+func NewFileAuditLog(path string) (*FileAuditLog, error) {
+	lines, err := openJSONLines(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditLog{lines: lines}, nil
+}
+
+func (l *FileAuditLog) Append(e *AuditEntry) error {
+	return l.lines.append(e, func(id uint64) { e.ID = id })
+}
+
+func (l *FileAuditLog) Each(f func(e AuditEntry) bool) error {
+	return l.lines.each(eachAuditEntry(f))
+}
+
+// MemoryAuditLog keeps entries in memory.
+type MemoryAuditLog struct {
+	lines memoryLines
+}
+
+func (l *MemoryAuditLog) Append(e *AuditEntry) error {
+	return l.lines.append(e, func(id uint64) { e.ID = id })
+}
+
+func (l *MemoryAuditLog) Each(f func(e AuditEntry) bool) error {
+	return l.lines.each(eachAuditEntry(f))
+}
+
+func eachAuditEntry(f func(e AuditEntry) bool) func(line []byte) (bool, error) {
+	return func(line []byte) (bool, error) {
+		var e AuditEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return false, err
+		}
+		return f(e), nil
+	}
+}
@@ -0,0 +1,64 @@
+package adi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+	l, err := NewFileAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog = l
+	defer func() { auditLog = nil }()
+	for _, c := range []string{"setprc", "setlvl", "setprc"} {
+		Audit(Message{Command: c, Channel: "CGENERAL"}, "x", "1", "2")
+	}
+	// the ids have to go on after a restart
+	if auditLog, err = NewFileAuditLog(path); err != nil {
+		t.Fatal(err)
+	}
+	Audit(Message{Command: "setvis"}, "x", "visible", "hidden")
+	tests := []struct {
+		command string
+		n       int
+		ids     []uint64
+	}{
+		{"", 10, []uint64{4, 3, 2, 1}},
+		{"", 2, []uint64{4, 3}},
+		{"setprc", 10, []uint64{3, 1}},
+		{"setprc", 1, []uint64{3}},
+		{"delmsg", 10, nil},
+	}
+	for _, test := range tests {
+		es, err := AuditEntries(func(e AuditEntry) bool {
+			return test.command == "" || e.Command == test.command
+		}, test.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []uint64
+		for _, e := range es {
+			ids = append(ids, e.ID)
+		}
+		if len(ids) != len(test.ids) {
+			t.Errorf("%q %d: expected %v, got %v", test.command, test.n, test.ids, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != test.ids[i] {
+				t.Errorf("%q %d: expected %v, got %v", test.command, test.n, test.ids, ids)
+				break
+			}
+		}
+	}
+}
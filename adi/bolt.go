@@ -0,0 +1,191 @@
+package adi
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketUsers    = []byte("users")
+	bucketCommands = []byte("commands")
+	bucketBank     = []byte("bank")
+	bucketRules    = []byte("rules")
+	keyBank        = []byte("bank")
+	keyRules       = []byte("rules")
+	storeBuckets   = [][]byte{bucketUsers, bucketCommands, bucketBank, bucketRules}
+)
+
+// BoltStore keeps every user and command as its own JSON encoded key
+// in a bbolt database. Only one process can open the database, others
+// give up after a second.
+type BoltStore struct {
+	db *bolt.DB
+	// written maps each bucket to its keys and what they hold in the
+	// database, so Save only writes what changed.
+	written map[string]map[string][]byte
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	bs := &BoltStore{db: db, written: map[string]map[string][]byte{}}
+	for _, name := range storeBuckets {
+		bs.written[string(name)] = map[string][]byte{}
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		for _, name := range storeBuckets {
+			b := tx.Bucket(name)
+			if b == nil {
+				continue
+			}
+			keys := bs.written[string(name)]
+			if err := b.ForEach(func(k, v []byte) error {
+				keys[string(k)] = append([]byte(nil), v...)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return bs, nil
+}
+
+func (bs *BoltStore) Load(users *[]*User, commands *[]*Command, bank *Bank, rules *[]Rule) error {
+	return bs.db.View(func(tx *bolt.Tx) error {
+		bb := tx.Bucket(bucketBank)
+		if bb == nil {
+			return ErrEmptyStore
+		}
+		if err := json.Unmarshal(bb.Get(keyBank), bank); err != nil {
+			return err
+		}
+		if b := tx.Bucket(bucketRules); b != nil {
+			if err := json.Unmarshal(b.Get(keyRules), rules); err != nil {
+				return err
+			}
+		}
+		if b := tx.Bucket(bucketUsers); b != nil {
+			err := b.ForEach(func(k, v []byte) error {
+				var u User
+				if err := json.Unmarshal(v, &u); err != nil {
+					return err
+				}
+				*users = append(*users, &u)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if b := tx.Bucket(bucketCommands); b != nil {
+			err := b.ForEach(func(k, v []byte) error {
+				var c Command
+				if err := json.Unmarshal(v, &c); err != nil {
+					return err
+				}
+				*commands = append(*commands, &c)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Save writes the users, commands, bank and rules that changed since
+// the last save and deletes the users and commands that are gone, all
+// in one transaction. Nothing is written if nothing changed.
+func (bs *BoltStore) Save(users []*User, commands []*Command, bank *Bank, rules []Rule) error {
+	items := map[string]map[string]interface{}{}
+	for _, name := range storeBuckets {
+		items[string(name)] = map[string]interface{}{}
+	}
+	for _, u := range users {
+		items[string(bucketUsers)][u.ID] = u
+	}
+	for _, c := range commands {
+		items[string(bucketCommands)][c.Name] = c
+	}
+	items[string(bucketRules)][string(keyRules)] = rules
+	items[string(bucketBank)][string(keyBank)] = bank
+	puts := map[string]map[string][]byte{}
+	dels := map[string][]string{}
+	for bucket, keys := range items {
+		for key, item := range keys {
+			data, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if old, ok := bs.written[bucket][key]; ok && bytes.Equal(old, data) {
+				continue
+			}
+			if puts[bucket] == nil {
+				puts[bucket] = map[string][]byte{}
+			}
+			puts[bucket][key] = data
+		}
+		for key := range bs.written[bucket] {
+			if _, ok := keys[key]; !ok {
+				dels[bucket] = append(dels[bucket], key)
+			}
+		}
+	}
+	if len(puts) == 0 && len(dels) == 0 {
+		return nil
+	}
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		for bucket, keys := range puts {
+			b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+			if err != nil {
+				return err
+			}
+			for key, data := range keys {
+				if err := b.Put([]byte(key), data); err != nil {
+					return err
+				}
+			}
+		}
+		for bucket, keys := range dels {
+			b := tx.Bucket([]byte(bucket))
+			if b == nil {
+				continue
+			}
+			for _, key := range keys {
+				if err := b.Delete([]byte(key)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for bucket, keys := range puts {
+		for key, data := range keys {
+			bs.written[bucket][key] = data
+		}
+	}
+	for bucket, keys := range dels {
+		for _, key := range keys {
+			delete(bs.written[bucket], key)
+		}
+	}
+	return nil
+}
+
+func (bs *BoltStore) Close() error {
+	return bs.db.Close()
+}
@@ -0,0 +1,221 @@
+package adi
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Check validates config.json and the users, commands, bank and rules
+// in the store it configures, without connecting to slack. It returns
+// every problem found.
+func Check(configPath string) []string {
+	var problems []string
+	c, err := readConfig(configPath)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if _, err := checkSettings(c); err != nil {
+		problems = append(problems, err.Error())
+	}
+	st, err := openStore(c)
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	defer st.Close()
+	var (
+		users    []*User
+		commands []*Command
+		bank     Bank
+		rules    []Rule
+	)
+	err = st.Load(&users, &commands, &bank, &rules)
+	if err == ErrEmptyStore {
+		err = NewFileStore(".", 0).Load(&users, &commands, &bank, &rules)
+	}
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	if len(c.Owners) == 0 {
+		problems = append(problems, "no owners are set")
+	}
+	problems = append(problems, checkUsers(users)...)
+	if err := checkCommands(commands); err != nil {
+		return append(problems, err.Error())
+	}
+	problems = append(problems, checkRules(rules, commands)...)
+	problems = append(problems, checkRoles(c.Roles, commands)...)
+	problems = append(problems, checkCommandFuncs(commands)...)
+	return append(problems, checkOldProxies(commands)...)
+}
+
+// checkRules returns the rules that do not name a known command or
+// module.
+func checkRules(rules []Rule, cmds []*Command) []string {
+	var problems []string
+	known := map[string]bool{}
+	for _, c := range cmds {
+		known[c.Name] = true
+	}
+	for _, name := range RegisteredFuncs() {
+		known[name] = true
+	}
+	modules := map[string]bool{}
+	for _, m := range Modules() {
+		modules[m] = true
+	}
+	for i, r := range rules {
+		switch {
+		case r.Channel == "":
+			problems = append(problems, fmt.Sprintf("rule %d has no channel", i+1))
+		case (r.Command == "") == (r.Module == ""):
+			problems = append(problems,
+				fmt.Sprintf("rule %d needs either a command or a module", i+1))
+		case r.Command != "" && !known[r.Command]:
+			problems = append(problems,
+				fmt.Sprintf("rule %d names unknown command %s", i+1, r.Command))
+		case r.Module != "" && !modules[r.Module]:
+			problems = append(problems,
+				fmt.Sprintf("rule %d names unknown module %s", i+1, r.Module))
+		}
+	}
+	return problems
+}
+
+// openStore returns the store configured in c.
+func openStore(c config) (Store, error) {
+	switch c.Store.Type {
+	case "", "file":
+		path := c.Store.Path
+		if path == "" {
+			path = "."
+		}
+		return NewFileStore(path, c.Store.Backups), nil
+	case "bolt":
+		path := c.Store.Path
+		if path == "" {
+			path = "./adi.db"
+		}
+		return NewBoltStore(path)
+	}
+	return nil, fmt.Errorf("unknown store type %s", c.Store.Type)
+}
+
+func checkUsers(users []*User) []string {
+	var problems []string
+	seen := map[string]bool{}
+	for i, u := range users {
+		if u == nil || u.ID == "" {
+			problems = append(problems, fmt.Sprintf("user %d has no id", i+1))
+			continue
+		}
+		if seen[u.ID] {
+			problems = append(problems, fmt.Sprintf("user %s is defined twice", u.ID))
+		}
+		seen[u.ID] = true
+	}
+	return problems
+}
+
+// checkCommandFuncs returns the commands without func and the proxies
+// that do not end at a command with func.
+func checkCommandFuncs(cmds []*Command) []string {
+	var problems []string
+	byName := map[string]*Command{}
+	for _, c := range cmds {
+		byName[c.Name] = c
+	}
+	for _, name := range RegisteredFuncs() {
+		if _, ok := byName[name]; !ok {
+			c := defaultCommand(name)
+			byName[name] = &c
+		}
+	}
+	for _, c := range cmds {
+		if c.Proxy != "" {
+			if err := CheckTemplate(c.Proxy); err != nil {
+				problems = append(problems,
+					fmt.Sprintf("proxy %s is invalid: %s", c.Name, err))
+				continue
+			}
+			if p := checkProxy(c, byName); p != "" {
+				problems = append(problems, p)
+			}
+			continue
+		}
+		if _, ok := commandFuncs[c.Name]; !ok {
+			problems = append(problems,
+				fmt.Sprintf("command %s has no func", c.Name))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// checkOldProxies returns the proxies that may have been written before
+// templates had placeholders and steps and now run something else.
+// Proxies set with setproxy since then have a creator.
+func checkOldProxies(cmds []*Command) []string {
+	var problems []string
+	for _, c := range cmds {
+		// invalid templates are reported by checkCommandFuncs
+		if c.Proxy == "" || c.Creator != "" || CheckTemplate(c.Proxy) != nil {
+			continue
+		}
+		if changes := oldTemplateChanges(c.Proxy); len(changes) > 0 {
+			problems = append(problems, fmt.Sprintf(
+				"proxy %s may be written for older versions, now %s",
+				c.Name, strings.Join(changes, ", ")))
+		}
+	}
+	return problems
+}
+
+// CheckProxy returns an error if name can not be set to the proxy
+// template because of the commands it runs. The state has to be
+// locked.
+func CheckProxy(name, template string) error {
+	byName := map[string]*Command{}
+	for _, c := range Commands {
+		byName[c.Name] = c
+	}
+	c := &Command{Name: name, Proxy: template}
+	byName[name] = c
+	if p := checkProxy(c, byName); p != "" {
+		return errors.New(p)
+	}
+	return nil
+}
+
+// checkProxy follows the proxy c until it reaches commands with func
+// and describes why it does not if so.
+func checkProxy(c *Command, byName map[string]*Command) string {
+	return followProxy(c, byName, []string{c.Name})
+}
+
+func followProxy(c *Command, byName map[string]*Command, path []string) string {
+	for _, name := range ProxyTargets(c.Proxy) {
+		next, ok := byName[name]
+		if !ok {
+			return fmt.Sprintf("proxy %s runs unknown command %q", c.Name, name)
+		}
+		for _, p := range path {
+			if p == name {
+				return fmt.Sprintf("proxy %s is a cycle: %s", path[0],
+					strings.Join(append(path, name), " -> "))
+			}
+		}
+		if next.Proxy == "" {
+			continue
+		}
+		if len(path) == maxProxyDepth {
+			return fmt.Sprintf("proxy %s nests more than %d proxies",
+				path[0], maxProxyDepth)
+		}
+		if p := followProxy(next, byName, append(path[:len(path):len(path)], name)); p != "" {
+			return p
+		}
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+package adi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, data string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"pts", "rank", "duel"} {
+		RegisterFunc(name, func(m Message, t Transport) Response { return Response{} })
+		defer delete(commandFuncs, name)
+	}
+	config := filepath.Join(dir, "config.json")
+	write("config.json", `{"store": {"path": "`+dir+`"}, "owners": ["U1"],
+		"roles": {"bank": ["pts", "move points", "fly"]}}`)
+	write("users.json", `[{"id": "U1"}, {"id": "U2"}, {"id": "U1"}]`)
+	write("bank.json", `{"points": 10}`)
+	write("commands.json", `[
+		{"name": "pts"},
+		{"name": "rank"},
+		{"name": "gone"},
+		{"name": "mine", "proxy": "pts %s"},
+		{"name": "lost", "proxy": "nope"},
+		{"name": "ping", "proxy": "pong"},
+		{"name": "pong", "proxy": "ping 1"},
+		{"name": "via", "proxy": "mine"},
+		{"name": "fight", "proxy": "duel bob 1"},
+		{"name": "odd", "proxy": "pts $who"},
+		{"name": "old", "proxy": "pts $5; rank $5 %s"},
+		{"name": "new", "proxy": "pts $1; rank", "creator": "U1"}
+	]`)
+	write("rules.json", `[
+		{"channel": "C1", "command": "rank"},
+		{"channel": "C1", "command": "nope"},
+		{"channel": "*", "module": "nomod"},
+		{"channel": "*"}
+	]`)
+	expected := []string{
+		"user U1 is defined twice",
+		"rule 2 names unknown command nope",
+		"rule 3 names unknown module nomod",
+		"rule 4 needs either a command or a module",
+		`role bank grants unknown permission "fly"`,
+		"command gone has no func",
+		`proxy lost runs unknown command "nope"`,
+		"proxy odd is invalid: unknown placeholder $who",
+		"proxy ping is a cycle: ping -> pong -> ping",
+		"proxy pong is a cycle: pong -> ping -> pong",
+		"proxy old may be written for older versions, now ; separates commands, $5 is a placeholder, %s is kept as is",
+	}
+	if problems := Check(config); !reflect.DeepEqual(problems, expected) {
+		t.Errorf("expected problems %q, got %q", expected, problems)
+	}
+
+	write("bank.json", `{"points": "many"}`)
+	if problems := Check(config); len(problems) != 1 {
+		t.Errorf("expected the broken bank.json to be found, got %q", problems)
+	}
+	write("config.json", `{"store": {"type": "paper"}}`)
+	if problems := Check(config); !reflect.DeepEqual(problems, []string{"unknown store type paper"}) {
+		t.Errorf("unexpected problems %q", problems)
+	}
+}
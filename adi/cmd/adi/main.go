@@ -1,9 +1,12 @@
 package main
 
 import (
+	"fmt"
+	"os"
 	"runtime"
 
 	"github.com/henkman/slackbot/adi"
+	_ "github.com/henkman/slackbot/adi/module/channels"
 	_ "github.com/henkman/slackbot/adi/module/level"
 	_ "github.com/henkman/slackbot/adi/module/misc"
 	_ "github.com/henkman/slackbot/adi/module/points"
@@ -16,6 +19,17 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		problems := adi.Check("./config.json")
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("ok")
+		return
+	}
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	adi.Run()
 }
@@ -0,0 +1,115 @@
+package adi
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// MaxLevel is the highest level. Commands that change what belongs to
+// others require it by default, so only users given that level can run
+// them until commands.json says otherwise.
+const MaxLevel Level = math.MaxUint8
+
+// IsAdmin reports whether u has the highest level and so may change
+// everything.
+func IsAdmin(u *User) bool {
+	return u.Level == MaxLevel
+}
+
+// MayEdit reports whether u may change or delete the proxy c, which
+// only its creator and those who may manage proxies may.
+func MayEdit(u *User, c *Command) bool {
+	return Can(u, ActionManageProxies) || c.Creator != "" && c.Creator == u.ID
+}
+
+// commandOverride is how a command is kept in commands.json. Fields
+// that are not set use the defaults of the module.
+type commandOverride struct {
+	Name          string         `json:"name"`
+	RequiredLevel *Level         `json:"required_level,omitempty"`
+	Price         *Points        `json:"price,omitempty"`
+	Visible       *bool          `json:"visible,omitempty"`
+	Proxy         string         `json:"proxy,omitempty"`
+	Creator       string         `json:"creator,omitempty"`
+	Timeout       *time.Duration `json:"timeout,omitempty"`
+	Cooldown      *Cooldown      `json:"cooldown,omitempty"`
+	Description   string         `json:"description,omitempty"`
+	Usage         string         `json:"usage,omitempty"`
+	Examples      []string       `json:"examples,omitempty"`
+}
+
+// defaultCommand returns the command name as its module registered it.
+// Commands without func, like proxies, have no defaults.
+func defaultCommand(name string) Command {
+	c := Command{Name: name}
+	if _, ok := commandFuncs[name]; !ok {
+		return c
+	}
+	c.Visible = true
+	if s := commandSpecs[name]; s != nil {
+		c.Price = s.Price
+		c.RequiredLevel = s.RequiredLevel
+		c.Visible = !s.Hidden
+		c.Timeout = s.Timeout
+		c.Cooldown = s.Cooldown
+	}
+	return c
+}
+
+func (c Command) MarshalJSON() ([]byte, error) {
+	d := defaultCommand(c.Name)
+	o := commandOverride{
+		Name:        c.Name,
+		Proxy:       c.Proxy,
+		Creator:     c.Creator,
+		Description: c.Description,
+		Usage:       c.Usage,
+		Examples:    c.Examples,
+	}
+	if c.RequiredLevel != d.RequiredLevel {
+		o.RequiredLevel = &c.RequiredLevel
+	}
+	if c.Price != d.Price {
+		o.Price = &c.Price
+	}
+	if c.Visible != d.Visible {
+		o.Visible = &c.Visible
+	}
+	if c.Timeout != d.Timeout {
+		o.Timeout = &c.Timeout
+	}
+	if c.Cooldown != d.Cooldown {
+		o.Cooldown = &c.Cooldown
+	}
+	return json.Marshal(o)
+}
+
+func (c *Command) UnmarshalJSON(data []byte) error {
+	var o commandOverride
+	if err := json.Unmarshal(data, &o); err != nil {
+		return err
+	}
+	*c = defaultCommand(o.Name)
+	c.Proxy = o.Proxy
+	c.Creator = o.Creator
+	c.Description = o.Description
+	c.Usage = o.Usage
+	c.Examples = o.Examples
+	if o.RequiredLevel != nil {
+		c.RequiredLevel = *o.RequiredLevel
+	}
+	if o.Price != nil {
+		c.Price = *o.Price
+	}
+	if o.Visible != nil {
+		c.Visible = *o.Visible
+	}
+	if o.Timeout != nil {
+		c.Timeout = *o.Timeout
+	}
+	if o.Cooldown != nil {
+		c.Cooldown = *o.Cooldown
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package adi
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCommandDefaults(t *testing.T) {
+	RegisterCommand("setfoo", Spec{Price: 2, RequiredLevel: 7},
+		func(m Message, t Transport) Response { return Response{} })
+	RegisterCommand("secret", Spec{Hidden: true},
+		func(m Message, t Transport) Response { return Response{} })
+	defer func() {
+		for _, name := range []string{"setfoo", "secret"} {
+			delete(commandFuncs, name)
+			delete(commandSpecs, name)
+			delete(commandModules, name)
+		}
+		Commands = nil
+	}()
+	var cmds []*Command
+	if err := json.Unmarshal([]byte(`[
+		{"name": "setfoo", "price": 5, "cooldown": {"user": 60000000000}},
+		{"name": "mine", "proxy": "setfoo", "visible": true}
+	]`), &cmds); err != nil {
+		t.Fatal(err)
+	}
+	Commands = cmds
+	ResetCommands()
+	tests := []struct {
+		name    string
+		price   Points
+		level   Level
+		visible bool
+	}{
+		{"setfoo", 5, 7, true},
+		{"mine", 0, 0, true},
+		{"secret", 0, 0, false},
+	}
+	for _, test := range tests {
+		c := GetCommandByName(test.name)
+		if c == nil {
+			t.Errorf("%s: not found", test.name)
+			continue
+		}
+		if c.Price != test.price || c.RequiredLevel != test.level || c.Visible != test.visible {
+			t.Errorf("%s: expected %d %d %v, got %d %d %v", test.name,
+				test.price, test.level, test.visible,
+				c.Price, c.RequiredLevel, c.Visible)
+		}
+	}
+	GetCommandByName("secret").RequiredLevel = 3
+	data, err := json.Marshal(Commands)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cd := GetCommandByName("setfoo").Cooldown; cd.User != time.Minute {
+		t.Errorf("expected setfoo to have a cooldown of a minute, got %v", cd)
+	}
+	expected := `[{"name":"setfoo","price":5,"cooldown":{"user":60000000000}},` +
+		`{"name":"mine","visible":true,"proxy":"setfoo"},` +
+		`{"name":"secret","required_level":3}]`
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+}
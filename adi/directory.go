@@ -0,0 +1,226 @@
+package adi
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// directory caches the users and channels of the chat. It is filled by
+// SyncDirectory and kept up to date by events, so the chat service does
+// not have to be asked every time a name is looked up.
+var directory struct {
+	sync.RWMutex
+	synced   bool
+	users    map[string]ChatUser
+	channels map[string]Channel
+}
+
+var (
+	reUserMention    = regexp.MustCompile(`^<@([A-Z0-9]+)(?:\|[^>]*)?>$`)
+	reChannelMention = regexp.MustCompile(`^<#([A-Z0-9]+)(?:\|[^>]*)?>$`)
+)
+
+// SyncDirectory replaces the cached users and channels with the ones t
+// knows. Users t knows no presence of keep the cached one, as the
+// presence is mostly learned from events.
+func SyncDirectory(t Transport) error {
+	us, err := t.GetUsers()
+	if err != nil {
+		return err
+	}
+	cs, err := t.GetChannels()
+	if err != nil {
+		return err
+	}
+	gs, err := t.GetGroups()
+	if err != nil {
+		return err
+	}
+	users := make(map[string]ChatUser, len(us))
+	for _, u := range us {
+		users[u.ID] = u
+	}
+	channels := make(map[string]Channel, len(cs)+len(gs))
+	for _, c := range cs {
+		channels[c.ID] = c
+	}
+	for _, g := range gs {
+		g.Private = true
+		channels[g.ID] = g
+	}
+	directory.Lock()
+	for id, u := range users {
+		if o, ok := directory.users[id]; ok && u.Presence == "" {
+			u.Presence = o.Presence
+			users[id] = u
+		}
+	}
+	directory.users = users
+	directory.channels = channels
+	directory.synced = true
+	directory.Unlock()
+	return nil
+}
+
+// PutUser adds or updates a cached user. An empty presence keeps the
+// cached one.
+func PutUser(u ChatUser) {
+	directory.Lock()
+	defer directory.Unlock()
+	if directory.users == nil {
+		directory.users = map[string]ChatUser{}
+	}
+	if o, ok := directory.users[u.ID]; ok && u.Presence == "" {
+		u.Presence = o.Presence
+	}
+	directory.users[u.ID] = u
+}
+
+// PutChannel adds or updates a cached channel.
+func PutChannel(c Channel) {
+	directory.Lock()
+	defer directory.Unlock()
+	if directory.channels == nil {
+		directory.channels = map[string]Channel{}
+	}
+	directory.channels[c.ID] = c
+}
+
+// SetPresence updates the presence of a cached user.
+func SetPresence(id, p string) {
+	directory.Lock()
+	defer directory.Unlock()
+	u, ok := directory.users[id]
+	if !ok {
+		return
+	}
+	u.Presence = p
+	directory.users[id] = u
+}
+
+// ChatUsers returns all cached users sorted by id.
+func ChatUsers() []ChatUser {
+	directory.RLock()
+	us := make([]ChatUser, 0, len(directory.users))
+	for _, u := range directory.users {
+		us = append(us, u)
+	}
+	directory.RUnlock()
+	sort.Slice(us, func(i, j int) bool { return us[i].ID < us[j].ID })
+	return us
+}
+
+// OnlineUsers returns the cached users that are active and no bots.
+func OnlineUsers() []ChatUser {
+	us := ChatUsers()
+	online := us[:0]
+	for _, u := range us {
+		if !u.IsBot && u.Presence == "active" {
+			online = append(online, u)
+		}
+	}
+	return online
+}
+
+// ChatUserByID returns the cached user with id or nil.
+func ChatUserByID(id string) *ChatUser {
+	directory.RLock()
+	defer directory.RUnlock()
+	u, ok := directory.users[id]
+	if !ok {
+		return nil
+	}
+	return &u
+}
+
+// NameOf returns the name of the user with id or id itself if the user
+// is unknown.
+func NameOf(id string) string {
+	if u := ChatUserByID(id); u != nil {
+		return u.Name
+	}
+	return id
+}
+
+// channelNameOf returns the name of the channel with id or id itself
+// if the channel is unknown.
+func channelNameOf(id string) string {
+	directory.RLock()
+	defer directory.RUnlock()
+	if c, ok := directory.channels[id]; ok {
+		return c.Name
+	}
+	return id
+}
+
+func ensureDirectory(t Transport) {
+	directory.RLock()
+	synced := directory.synced
+	directory.RUnlock()
+	if synced {
+		return
+	}
+	if err := SyncDirectory(t); err != nil {
+		log.Println("ERROR:", err)
+	}
+}
+
+// ResolveUser finds a user by mention like <@U123>, id, name with or
+// without @ or display name.
+func ResolveUser(t Transport, s string) *ChatUser {
+	ensureDirectory(t)
+	s = strings.TrimSpace(s)
+	if m := reUserMention.FindStringSubmatch(s); m != nil {
+		return ChatUserByID(m[1])
+	}
+	s = strings.TrimPrefix(s, "@")
+	if s == "" {
+		return nil
+	}
+	directory.RLock()
+	defer directory.RUnlock()
+	if u, ok := directory.users[s]; ok {
+		return &u
+	}
+	var found *ChatUser
+	for _, u := range directory.users {
+		if u.Name == s {
+			return &u
+		}
+		if found == nil && u.DisplayName != "" &&
+			strings.EqualFold(u.DisplayName, s) {
+			u := u
+			found = &u
+		}
+	}
+	return found
+}
+
+// ResolveChannel finds a channel by mention like <#C123|name>, id or
+// name with or without #.
+func ResolveChannel(t Transport, s string) *Channel {
+	ensureDirectory(t)
+	s = strings.TrimSpace(s)
+	directory.RLock()
+	defer directory.RUnlock()
+	if m := reChannelMention.FindStringSubmatch(s); m != nil {
+		c, ok := directory.channels[m[1]]
+		if !ok {
+			return nil
+		}
+		return &c
+	}
+	s = strings.TrimPrefix(s, "#")
+	if c, ok := directory.channels[s]; ok {
+		return &c
+	}
+	for _, c := range directory.channels {
+		if c.Name == s {
+			return &c
+		}
+	}
+	return nil
+}
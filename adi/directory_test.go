@@ -0,0 +1,84 @@
+package adi_test
+
+import (
+	"testing"
+
+	"github.com/henkman/slackbot/adi"
+	"github.com/henkman/slackbot/adi/fake"
+)
+
+func TestResolve(t *testing.T) {
+	ft := fake.New()
+	ft.AddUser(adi.ChatUser{ID: "U1", Name: "alice", DisplayName: "Alice A."})
+	ft.AddUser(adi.ChatUser{ID: "U2", Name: "bob"})
+	ft.AddChannel(adi.Channel{ID: "C1", Name: "general"})
+	ft.AddGroup(adi.Channel{ID: "G1", Name: "secret"})
+	if err := adi.SyncDirectory(ft); err != nil {
+		t.Fatal(err)
+	}
+	users := []struct {
+		text string
+		id   string
+	}{
+		{"alice", "U1"},
+		{"@alice", "U1"},
+		{"<@U1>", "U1"},
+		{"<@U1|alice>", "U1"},
+		{"U2", "U2"},
+		{"alice a.", "U1"},
+		{" bob ", "U2"},
+		{"carol", ""},
+		{"<@U9>", ""},
+		{"@", ""},
+	}
+	for _, test := range users {
+		u := adi.ResolveUser(ft, test.text)
+		if (u == nil && test.id != "") || (u != nil && u.ID != test.id) {
+			t.Errorf("%q: expected %q, got %v", test.text, test.id, u)
+		}
+	}
+	channels := []struct {
+		text string
+		id   string
+	}{
+		{"general", "C1"},
+		{"#general", "C1"},
+		{"<#C1|general>", "C1"},
+		{"<#C1>", "C1"},
+		{"secret", "G1"},
+		{"random", ""},
+	}
+	for _, test := range channels {
+		c := adi.ResolveChannel(ft, test.text)
+		if (c == nil && test.id != "") || (c != nil && c.ID != test.id) {
+			t.Errorf("%q: expected %q, got %v", test.text, test.id, c)
+		}
+	}
+	if c := adi.GetChannelByName(ft, "secret"); c != nil {
+		t.Errorf("expected private channel to be no public channel")
+	}
+	adi.PutUser(adi.ChatUser{ID: "U3", Name: "carol"})
+	if u := adi.ResolveUser(ft, "carol"); u == nil || u.ID != "U3" {
+		t.Errorf("expected carol to be found after she joined, got %v", u)
+	}
+}
+
+func TestResyncKeepsPresence(t *testing.T) {
+	ft := fake.New()
+	ft.AddUser(adi.ChatUser{ID: "U1", Name: "alice"})
+	ft.AddUser(adi.ChatUser{ID: "U2", Name: "bob", Presence: "away"})
+	if err := adi.SyncDirectory(ft); err != nil {
+		t.Fatal(err)
+	}
+	adi.SetPresence("U1", "active")
+	adi.SetPresence("U2", "active")
+	if err := adi.SyncDirectory(ft); err != nil {
+		t.Fatal(err)
+	}
+	if u := adi.ChatUserByID("U1"); u == nil || u.Presence != "active" {
+		t.Errorf("expected alice to stay active, got %v", u)
+	}
+	if u := adi.ChatUserByID("U2"); u == nil || u.Presence != "away" {
+		t.Errorf("expected the presence bob has in the chat, got %v", u)
+	}
+}
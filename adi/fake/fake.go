@@ -0,0 +1,179 @@
+// Package fake implements an in-memory adi.Transport to drive adi
+// without a chat service.
+package fake
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/henkman/slackbot/adi"
+)
+
+type Message struct {
+	Channel string
+	Text    string
+	Rich    bool
+}
+
+type Deletion struct {
+	Channel   string
+	Timestamp string
+}
+
+type Transport struct {
+	mu       sync.Mutex
+	users    []adi.ChatUser
+	channels []adi.Channel
+	groups   []adi.Channel
+	sent     []Message
+	deleted  []Deletion
+	typing   []string
+}
+
+func New() *Transport {
+	return &Transport{}
+}
+
+func (t *Transport) AddUser(u adi.ChatUser) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, _ := range t.users {
+		if t.users[i].ID == u.ID {
+			t.users[i] = u
+			return
+		}
+	}
+	t.users = append(t.users, u)
+}
+
+func (t *Transport) AddChannel(c adi.Channel) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.channels = append(t.channels, c)
+}
+
+func (t *Transport) AddGroup(c adi.Channel) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.groups = append(t.groups, c)
+}
+
+func (t *Transport) SetPresence(id, presence string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, _ := range t.users {
+		if t.users[i].ID == id {
+			t.users[i].Presence = presence
+			return
+		}
+	}
+}
+
+// Messages returns everything sent through the transport so far.
+func (t *Transport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ms := make([]Message, len(t.sent))
+	copy(ms, t.sent)
+	return ms
+}
+
+func (t *Transport) Deletions() []Deletion {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ds := make([]Deletion, len(t.deleted))
+	copy(ds, t.deleted)
+	return ds
+}
+
+// Typings returns the channels typing was shown in.
+func (t *Transport) Typings() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cs := make([]string, len(t.typing))
+	copy(cs, t.typing)
+	return cs
+}
+
+// Reset forgets sent messages, deletions and typing but keeps the
+// workspace.
+func (t *Transport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = nil
+	t.deleted = nil
+	t.typing = nil
+}
+
+func (t *Transport) SendMessage(channel, text string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = append(t.sent, Message{Channel: channel, Text: text})
+}
+
+func (t *Transport) Typing(channel string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.typing = append(t.typing, channel)
+}
+
+func (t *Transport) PostMessage(channel string, r adi.Response) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = append(t.sent, Message{Channel: channel, Text: r.Text, Rich: true})
+	return nil
+}
+
+func (t *Transport) GetUsers() ([]adi.ChatUser, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	us := make([]adi.ChatUser, len(t.users))
+	copy(us, t.users)
+	return us, nil
+}
+
+func (t *Transport) GetUserInfo(id string) (*adi.ChatUser, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, u := range t.users {
+		if u.ID == id {
+			return &u, nil
+		}
+	}
+	return nil, errors.New("user_not_found")
+}
+
+func (t *Transport) GetChannels() ([]adi.Channel, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cs := make([]adi.Channel, len(t.channels))
+	copy(cs, t.channels)
+	return cs, nil
+}
+
+func (t *Transport) GetGroups() ([]adi.Channel, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	gs := make([]adi.Channel, len(t.groups))
+	copy(gs, t.groups)
+	return gs, nil
+}
+
+// OpenIMChannel returns "D" followed by the user id as IM channel.
+func (t *Transport) OpenIMChannel(user string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, u := range t.users {
+		if u.ID == user {
+			return "D" + u.ID, nil
+		}
+	}
+	return "", errors.New("user_not_found")
+}
+
+func (t *Transport) DeleteMessage(channel, timestamp string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deleted = append(t.deleted, Deletion{channel, timestamp})
+	return nil
+}
@@ -0,0 +1,101 @@
+package adi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Summary returns what the command does, as set in commands.json or
+// registered by its module.
+func (c *Command) Summary() string {
+	if c.Description != "" {
+		return c.Description
+	}
+	if c.Spec != nil {
+		return c.Spec.Description
+	}
+	return ""
+}
+
+// Syntax returns how the command is used, like "pts [user]".
+func (c *Command) Syntax() string {
+	if c.Usage != "" {
+		return c.Usage
+	}
+	return c.Spec.Usage(c.Name)
+}
+
+// CommandHelp returns everything known about c. The state has to be
+// locked.
+func CommandHelp(c *Command) string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	if s := c.Summary(); s != "" {
+		b.WriteString(": " + s)
+	}
+	if c.Proxy != "" {
+		fmt.Fprintf(&b, "\nruns \"%s\"", c.Proxy)
+	} else {
+		b.WriteString("\nsyntax: " + c.Syntax())
+	}
+	fmt.Fprintf(&b, "\ncosts %d points, requires level %d",
+		c.Price, c.RequiredLevel)
+	if cd := c.Cooldown.String(); cd != "" {
+		b.WriteString("\ncooldown: " + cd)
+	}
+	examples := c.Examples
+	if len(examples) == 0 && c.Spec != nil {
+		examples = c.Spec.Examples
+	}
+	if len(examples) > 0 {
+		b.WriteString("\nexamples:")
+		for _, e := range examples {
+			b.WriteString("\n  " + e)
+		}
+	}
+	return b.String()
+}
+
+// HelpFor returns the visible commands u may run in channel, grouped
+// by module, with their price and required level if they have one. The
+// state has to be locked.
+func HelpFor(u *User, channel string) string {
+	modules := map[string][]string{}
+	for _, c := range Commands {
+		if !c.Visible || !Allowed(u, c) || !Enabled(c, channel) {
+			continue
+		}
+		var notes []string
+		if c.Price > 0 {
+			notes = append(notes, fmt.Sprintf("%d points", c.Price))
+		}
+		if c.RequiredLevel > 0 {
+			notes = append(notes, fmt.Sprintf("level %d", c.RequiredLevel))
+		}
+		e := c.Name
+		if len(notes) > 0 {
+			e += " (" + strings.Join(notes, ", ") + ")"
+		}
+		module := c.Module
+		if c.Proxy != "" {
+			module = "proxies"
+		} else if module == "" {
+			module = "other"
+		}
+		modules[module] = append(modules[module], e)
+	}
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		cmds := modules[name]
+		sort.Strings(cmds)
+		fmt.Fprintf(&b, "%s: %s\n", name, strings.Join(cmds, ", "))
+	}
+	b.WriteString("try 'help [command]' for details")
+	return b.String()
+}
@@ -0,0 +1,136 @@
+package adi
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// jsonLines are the entries of an append only log, like the ledger or
+// the audit log, as JSON lines in a file. Every entry has an id
+// counting up from 1.
+type jsonLines struct {
+	mu     sync.Mutex
+	path   string
+	lastID uint64
+}
+
+func openJSONLines(path string) (*jsonLines, error) {
+	l := &jsonLines{path: path}
+	if err := l.each(func(line []byte) (bool, error) {
+		var e struct {
+			ID uint64 `json:"id"`
+		}
+		if err := json.Unmarshal(line, &e); err != nil {
+			return false, err
+		}
+		l.lastID = e.ID
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// append calls setID with the id of the next entry and appends v.
+func (l *jsonLines) append(v interface{}, setID func(id uint64)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fd, err := os.OpenFile(l.path,
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	setID(l.lastID + 1)
+	if err := json.NewEncoder(fd).Encode(v); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	l.lastID++
+	return fd.Close()
+}
+
+// each calls f with every entry from the oldest to the newest until f
+// returns false or an error.
+func (l *jsonLines) each(f func(line []byte) (bool, error)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fd, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	s := bufio.NewScanner(fd)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		ok, err := f(s.Bytes())
+		if err != nil {
+			return fmt.Errorf("%s: %s", l.path, err)
+		}
+		if !ok {
+			break
+		}
+	}
+	return s.Err()
+}
+
+// memoryLines are like jsonLines, but kept in memory. The zero value
+// is empty and ready to use.
+type memoryLines struct {
+	mu    sync.Mutex
+	lines [][]byte
+}
+
+func (l *memoryLines) append(v interface{}, setID func(id uint64)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	setID(uint64(len(l.lines) + 1))
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	l.lines = append(l.lines, line)
+	return nil
+}
+
+func (l *memoryLines) each(f func(line []byte) (bool, error)) error {
+	l.mu.Lock()
+	lines := l.lines
+	l.mu.Unlock()
+	for _, line := range lines {
+		ok, err := f(line)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+	}
+	return nil
+}
+
+// lastN returns the last n values each passes to keep, the newest
+// first.
+func lastN(n int, each func(keep func(v interface{})) error) ([]interface{}, error) {
+	vs := make([]interface{}, 0, n)
+	err := each(func(v interface{}) {
+		if len(vs) == n {
+			copy(vs, vs[1:])
+			vs = vs[:n-1]
+		}
+		vs = append(vs, v)
+	})
+	for i, j := 0, len(vs)-1; i < j; i, j = i+1, j-1 {
+		vs[i], vs[j] = vs[j], vs[i]
+	}
+	return vs, err
+}
@@ -0,0 +1,287 @@
+package adi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// Mint is the source of points that entered the economy from outside,
+// like the opening balances when the ledger was started.
+const Mint = "mint"
+
+type Transaction struct {
+	ID       uint64    `json:"id"`
+	Time     time.Time `json:"time"`
+	Src      string    `json:"src"`
+	Dst      string    `json:"dst"`
+	Amount   Points    `json:"amount"`
+	Reason   string    `json:"reason"`
+	Message  string    `json:"message,omitempty"`
+	Reverses uint64    `json:"reverses,omitempty"`
+}
+
+// Ledger records every movement of points. Transactions can only be
+// appended, never changed.
+type Ledger interface {
+	// Append sets the ID of tx and records it.
+	Append(tx *Transaction) error
+	// Each calls f for every transaction from the oldest to the newest
+	// until f returns false.
+	Each(f func(tx Transaction) bool) error
+}
+
+var ledger Ledger
+
+// SetLedger makes adi record transactions in l. If l is empty the
+// current balances are recorded as opening balances.
+func SetLedger(l Ledger) error {
+	Lock()
+	defer Unlock()
+	ledger = l
+	empty := true
+	if err := l.Each(func(tx Transaction) bool {
+		empty = false
+		return false
+	}); err != nil {
+		return err
+	}
+	if !empty {
+		return nil
+	}
+	for _, a := range accounts() {
+		if a.Balance() == 0 {
+			continue
+		}
+		if err := l.Append(&Transaction{
+			Time:   time.Now().UTC(),
+			Src:    Mint,
+			Dst:    a.AccountID(),
+			Amount: a.Balance(),
+			Reason: "opening balance",
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Transfer moves n points from src to dst and records it in the
+// ledger. It never moves more than src has and returns the points
+// moved. The state has to be locked.
+func Transfer(src, dst Account, n Points, reason, message string) Points {
+	if n > src.Balance() {
+		n = src.Balance()
+	}
+	if n == 0 {
+		return 0
+	}
+	src.Sub(n)
+	dst.Add(n)
+	Changed()
+	if ledger != nil {
+		if err := ledger.Append(&Transaction{
+			Time:    time.Now().UTC(),
+			Src:     src.AccountID(),
+			Dst:     dst.AccountID(),
+			Amount:  n,
+			Reason:  reason,
+			Message: message,
+		}); err != nil {
+			log.Println("ERROR: ledger:", err)
+		}
+	}
+	return n
+}
+
+// Deposit adds n points from outside the economy to dst and records
+// it in the ledger. The state has to be locked.
+func Deposit(dst Account, n Points, reason string) {
+	if n == 0 {
+		return
+	}
+	dst.Add(n)
+	Changed()
+	if ledger != nil {
+		if err := ledger.Append(&Transaction{
+			Time:   time.Now().UTC(),
+			Src:    Mint,
+			Dst:    dst.AccountID(),
+			Amount: n,
+			Reason: reason,
+		}); err != nil {
+			log.Println("ERROR: ledger:", err)
+		}
+	}
+}
+
+// Reverse moves the points of transaction id back and records that as
+// a new transaction. It fails if the transaction was a deposit, was
+// already reversed or its destination does not have the points anymore.
+// The state has to be locked.
+func Reverse(id uint64, message string) (Transaction, error) {
+	if ledger == nil {
+		return Transaction{}, errors.New("no ledger")
+	}
+	// ids start at 1, Reverses is 0 for all but reversals
+	if id == 0 {
+		return Transaction{}, fmt.Errorf("transaction #%d not found", id)
+	}
+	var (
+		tx       Transaction
+		found    bool
+		reversed bool
+	)
+	if err := ledger.Each(func(o Transaction) bool {
+		if o.ID == id {
+			tx = o
+			found = true
+		}
+		if o.Reverses == id {
+			reversed = true
+			return false
+		}
+		return true
+	}); err != nil {
+		return Transaction{}, err
+	}
+	if !found {
+		return Transaction{}, fmt.Errorf("transaction #%d not found", id)
+	}
+	if reversed {
+		return Transaction{}, fmt.Errorf("transaction #%d was already reversed", id)
+	}
+	if tx.Src == Mint {
+		return Transaction{}, fmt.Errorf("transaction #%d is a deposit", id)
+	}
+	src := accountByID(tx.Dst)
+	dst := accountByID(tx.Src)
+	if src == nil || dst == nil {
+		return Transaction{}, fmt.Errorf(
+			"accounts of transaction #%d do not exist anymore", id)
+	}
+	if src.Balance() < tx.Amount {
+		return Transaction{}, fmt.Errorf("%s has only %d points",
+			NameOf(tx.Dst), src.Balance())
+	}
+	src.Sub(tx.Amount)
+	dst.Add(tx.Amount)
+	Changed()
+	rtx := Transaction{
+		Time:     time.Now().UTC(),
+		Src:      tx.Dst,
+		Dst:      tx.Src,
+		Amount:   tx.Amount,
+		Reason:   "reverse",
+		Message:  message,
+		Reverses: id,
+	}
+	if err := ledger.Append(&rtx); err != nil {
+		log.Println("ERROR: ledger:", err)
+	}
+	return rtx, nil
+}
+
+// History returns the last n transactions from or to account, the
+// newest first. The state has to be locked.
+func History(account string, n int) ([]Transaction, error) {
+	if ledger == nil {
+		return nil, nil
+	}
+	vs, err := lastN(n, func(keep func(v interface{})) error {
+		return ledger.Each(func(tx Transaction) bool {
+			if tx.Src == account || tx.Dst == account {
+				keep(tx)
+			}
+			return true
+		})
+	})
+	txs := make([]Transaction, len(vs))
+	for i, v := range vs {
+		txs[i] = v.(Transaction)
+	}
+	return txs, err
+}
+
+// AuditLedger replays the ledger and returns a description of every
+// account whose balance differs from it. The state has to be locked.
+func AuditLedger() ([]string, error) {
+	if ledger == nil {
+		return nil, nil
+	}
+	balances := map[string]Points{}
+	err := ledger.Each(func(tx Transaction) bool {
+		if tx.Src != Mint {
+			balances[tx.Src] -= tx.Amount
+		}
+		balances[tx.Dst] += tx.Amount
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	var diffs []string
+	for _, a := range accounts() {
+		id := a.AccountID()
+		if b := balances[id]; b != a.Balance() {
+			diffs = append(diffs, fmt.Sprintf(
+				"%s has %d points, ledger says %d", id, a.Balance(), b))
+		}
+		delete(balances, id)
+	}
+	for id, b := range balances {
+		if b != 0 {
+			diffs = append(diffs, fmt.Sprintf(
+				"%s is unknown, ledger says %d", id, b))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs, nil
+}
+
+// FileLedger appends transactions as JSON lines to a file.
+type FileLedger struct {
+	lines *jsonLines
+}
+
+func NewFileLedger(path string) (*FileLedger, error) {
+	lines, err := openJSONLines(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileLedger{lines: lines}, nil
+}
+
+func (l *FileLedger) Append(tx *Transaction) error {
+	return l.lines.append(tx, func(id uint64) { tx.ID = id })
+}
+
+func (l *FileLedger) Each(f func(tx Transaction) bool) error {
+	return l.lines.each(eachTransaction(f))
+}
+
+// MemoryLedger keeps transactions in memory.
+type MemoryLedger struct {
+	lines memoryLines
+}
+
+func (l *MemoryLedger) Append(tx *Transaction) error {
+	return l.lines.append(tx, func(id uint64) { tx.ID = id })
+}
+
+func (l *MemoryLedger) Each(f func(tx Transaction) bool) error {
+	return l.lines.each(eachTransaction(f))
+}
+
+func eachTransaction(f func(tx Transaction) bool) func(line []byte) (bool, error) {
+	return func(line []byte) (bool, error) {
+		var tx Transaction
+		if err := json.Unmarshal(line, &tx); err != nil {
+			return false, err
+		}
+		return f(tx), nil
+	}
+}
@@ -0,0 +1,222 @@
+package adi
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cooldown is how long a command can not run again after it ran for
+// the same user, in the same channel or at all. Zero means no cooldown.
+type Cooldown struct {
+	User    time.Duration `json:"user,omitempty"`
+	Channel time.Duration `json:"channel,omitempty"`
+	Global  time.Duration `json:"global,omitempty"`
+}
+
+func (c Cooldown) String() string {
+	var parts []string
+	if c.User > 0 {
+		parts = append(parts, fmt.Sprintf("%s per user", c.User))
+	}
+	if c.Channel > 0 {
+		parts = append(parts, fmt.Sprintf("%s per channel", c.Channel))
+	}
+	if c.Global > 0 {
+		parts = append(parts, fmt.Sprintf("%s overall", c.Global))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// RateLimit lets Burst commands run at once and PerMinute more every
+// minute after that. A zero PerMinute means no limit.
+type RateLimit struct {
+	PerMinute float64 `json:"per_minute"`
+	Burst     int     `json:"burst"`
+}
+
+// bucket is a token bucket. It holds the tokens left at last.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// fill adds the tokens gained since last and returns them.
+func (b *bucket) fill(l RateLimit, now time.Time) float64 {
+	b.tokens += now.Sub(b.last).Minutes() * l.PerMinute
+	if b.tokens > float64(l.burst()) {
+		b.tokens = float64(l.burst())
+	}
+	b.last = now
+	return b.tokens
+}
+
+// wait returns how long it takes until b has a token.
+func (b *bucket) wait(l RateLimit) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / l.PerMinute * float64(time.Minute))
+}
+
+func (l RateLimit) burst() int {
+	if l.Burst < 1 {
+		return 1
+	}
+	return l.Burst
+}
+
+// limits keeps track of cooldowns and rate limits. It has its own lock
+// as it is used for every command, even ones that do not touch the
+// state.
+var limits struct {
+	sync.Mutex
+	user, channel RateLimit
+	// until maps "command user:id", "command channel:id" and "command"
+	// to the end of its cooldown.
+	until   map[string]time.Time
+	buckets map[string]*bucket
+}
+
+// SetRateLimits sets the rate limits for every user and every channel.
+func SetRateLimits(user, channel RateLimit) {
+	limits.Lock()
+	defer limits.Unlock()
+	limits.user, limits.channel = user, channel
+}
+
+// cooldownKeys returns the keys of the cooldowns of cmd for user in
+// channel and how long they are.
+func cooldownKeys(cmd *Command, channel, user string) []struct {
+	key string
+	d   time.Duration
+} {
+	return []struct {
+		key string
+		d   time.Duration
+	}{
+		{cmd.Name + " user:" + user, cmd.Cooldown.User},
+		{cmd.Name + " channel:" + channel, cmd.Cooldown.Channel},
+		{cmd.Name, cmd.Cooldown.Global},
+	}
+}
+
+// rateKeys returns the keys of the buckets of user and channel and
+// their rate limits. limits has to be locked.
+func rateKeys(channel, user string) []struct {
+	key string
+	l   RateLimit
+} {
+	return []struct {
+		key string
+		l   RateLimit
+	}{
+		{"user:" + user, limits.user},
+		{"channel:" + channel, limits.channel},
+	}
+}
+
+// throttle returns an error meant for the user if the commands of a
+// message, every command of every stage, may not run for user in
+// channel yet. Nothing is counted, that is left to startCooldown and
+// spendRate once a command was charged, so refused runs cost nothing.
+func throttle(cmds []*Command, channel, user string, now time.Time) error {
+	limits.Lock()
+	defer limits.Unlock()
+	if limits.until == nil {
+		limits.until = map[string]time.Time{}
+		limits.buckets = map[string]*bucket{}
+	}
+	var wait time.Duration
+	seen := map[string]bool{}
+	for _, cmd := range cmds {
+		for _, c := range cooldownKeys(cmd, channel, user) {
+			if d := limits.until[c.key].Sub(now); c.d > 0 && d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			return fmt.Errorf("%s is cooling down. try again in %s",
+				cmd.Name, seconds(wait))
+		}
+		if seen[cmd.Name] && cmd.Cooldown != (Cooldown{}) {
+			return fmt.Errorf("%s can only run once as it cools down for %s",
+				cmd.Name, cmd.Cooldown)
+		}
+		seen[cmd.Name] = true
+	}
+	for _, r := range rateKeys(channel, user) {
+		if r.l.PerMinute <= 0 {
+			continue
+		}
+		b, ok := limits.buckets[r.key]
+		if !ok {
+			b = &bucket{tokens: float64(r.l.burst()), last: now}
+			limits.buckets[r.key] = b
+		}
+		b.fill(r.l, now)
+		if d := b.wait(r.l); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		return fmt.Errorf("slow down. try again in %s", seconds(wait))
+	}
+	return nil
+}
+
+// startCooldown starts the cooldowns of cmd after it ran and was
+// charged.
+func startCooldown(cmd *Command, channel, user string, now time.Time) {
+	limits.Lock()
+	defer limits.Unlock()
+	if limits.until == nil {
+		limits.until = map[string]time.Time{}
+		limits.buckets = map[string]*bucket{}
+	}
+	for _, c := range cooldownKeys(cmd, channel, user) {
+		if c.d > 0 {
+			limits.until[c.key] = now.Add(c.d)
+		}
+	}
+}
+
+// spendRate takes a token from the buckets of user and channel after a
+// message ran commands that were charged.
+func spendRate(channel, user string, now time.Time) {
+	limits.Lock()
+	defer limits.Unlock()
+	for _, r := range rateKeys(channel, user) {
+		if b, ok := limits.buckets[r.key]; ok && r.l.PerMinute > 0 {
+			b.fill(r.l, now)
+			b.tokens--
+		}
+	}
+}
+
+// seconds returns d rounded up to whole seconds, like "3s".
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%ds", int64(math.Ceil(d.Seconds())))
+}
+
+// pruneLimits forgets cooldowns that ended and buckets that are full.
+func pruneLimits(now time.Time) {
+	limits.Lock()
+	defer limits.Unlock()
+	for k, t := range limits.until {
+		if !t.After(now) {
+			delete(limits.until, k)
+		}
+	}
+	for k, b := range limits.buckets {
+		l := limits.user
+		if strings.HasPrefix(k, "channel:") {
+			l = limits.channel
+		}
+		if l.PerMinute <= 0 || b.fill(l, now) >= float64(l.burst()) {
+			delete(limits.buckets, k)
+		}
+	}
+}
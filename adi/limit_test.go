@@ -0,0 +1,57 @@
+package adi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	SetRateLimits(RateLimit{}, RateLimit{PerMinute: 2, Burst: 2})
+	defer SetRateLimits(RateLimit{}, RateLimit{})
+	duel := &Command{Name: "duel", Cooldown: Cooldown{Channel: time.Second * 30}}
+	pts := &Command{Name: "pts"}
+	start := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		cmd     *Command
+		channel string
+		after   time.Duration
+		err     string
+	}{
+		{duel, "C1", 0, ""},
+		{duel, "C1", time.Second * 10, "duel is cooling down. try again in 20s"},
+		{duel, "C2", time.Second * 10, ""},
+		{pts, "C1", time.Second * 10, ""},
+		{pts, "C1", time.Second * 10, "slow down. try again in 20s"},
+		{pts, "C1", time.Second * 40, ""},
+		{duel, "C1", time.Second * 40, "slow down. try again in 20s"},
+		{duel, "C1", time.Second * 70, ""},
+	}
+	for i, test := range tests {
+		now := start.Add(test.after)
+		err := throttle([]*Command{test.cmd}, test.channel, "U1", now)
+		if s := errString(err); s != test.err {
+			t.Errorf("%d: expected %q, got %q", i, test.err, s)
+		}
+		if err == nil {
+			startCooldown(test.cmd, test.channel, "U1", now)
+			spendRate(test.channel, "U1", now)
+		}
+	}
+	// a command that cools down can not run twice in one message
+	err := throttle([]*Command{pts, duel, duel}, "C3", "U1", start.Add(time.Hour))
+	if s := errString(err); s != "duel can only run once as it cools down for 30s per channel" {
+		t.Errorf("unexpected error %q", s)
+	}
+	pruneLimits(start.Add(time.Hour))
+	if len(limits.until) != 0 || len(limits.buckets) != 0 {
+		t.Errorf("expected everything to be pruned, got %v %v",
+			limits.until, limits.buckets)
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,142 @@
+package channels
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/henkman/slackbot/adi"
+)
+
+var ruleSpec = adi.Spec{
+	Args: []adi.Arg{
+		{Name: "target", Type: adi.ArgWord},
+		{Name: "channel", Type: adi.ArgChannel, Optional: true},
+	},
+	Flags: []adi.Flag{
+		{Name: "all"},
+		{Name: "module"},
+	},
+	RequiredLevel: adi.MaxLevel,
+}
+
+func init() {
+	spec := ruleSpec
+	spec.Description = "enables a command or module in this or another channel"
+	spec.Examples = []string{"enable glnsfw random", "enable --module google"}
+	adi.RegisterCommand("enable", spec,
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return setRule(m, tr, true)
+		})
+
+	spec = ruleSpec
+	spec.Description = "disables a command or module in this or another channel"
+	spec.Examples = []string{"disable --all glnsfw", "disable --module poll"}
+	adi.RegisterCommand("disable", spec,
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return setRule(m, tr, false)
+		})
+
+	adi.RegisterCommand("rules",
+		adi.Spec{
+			Description: "lists where commands and modules are enabled or disabled",
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			adi.RLock()
+			defer adi.RUnlock()
+			if len(adi.Rules) == 0 {
+				return adi.Response{
+					Text:   "everything is enabled everywhere",
+					Charge: true,
+				}
+			}
+			lines := make([]string, 0, len(adi.Rules))
+			for _, r := range adi.Rules {
+				lines = append(lines, describe(r, tr))
+			}
+			sort.Strings(lines)
+			return adi.Response{
+				Text:   strings.Join(lines, "\n"),
+				Charge: true,
+			}
+		})
+}
+
+// setRule enables or disables the target of m in the channel of m.
+func setRule(m adi.Message, tr adi.Transport, enabled bool) adi.Response {
+	if !adi.LockChange(m) {
+		return adi.Response{}
+	}
+	defer adi.Unlock()
+	r := adi.Rule{
+		Channel: m.Channel,
+		Enabled: enabled,
+	}
+	if c := m.Args.Channel("channel"); c != nil {
+		r.Channel = c.ID
+	}
+	if _, ok := m.Args.Flag("all"); ok {
+		if m.Args.Has("channel") {
+			return adi.Response{
+				Text: "either give a channel or --all",
+			}
+		}
+		r.Channel = adi.AllChannels
+	}
+	target := m.Args.String("target")
+	_, module := m.Args.Flag("module")
+	if c := adi.GetCommandByName(target); c != nil && !module {
+		if c.Module == "channels" {
+			return adi.Response{
+				Text: fmt.Sprintf("%s can not be disabled", target),
+			}
+		}
+		r.Command = target
+	} else if isModule(target) {
+		if target == "channels" {
+			return adi.Response{
+				Text: fmt.Sprintf("%s can not be disabled", target),
+			}
+		}
+		r.Module = target
+	} else {
+		return adi.Response{
+			Text: fmt.Sprintf("%s is neither a command nor a module", target),
+		}
+	}
+	adi.SetRule(r)
+	return adi.Response{
+		Text:   describe(r, tr),
+		Charge: true,
+	}
+}
+
+func isModule(name string) bool {
+	for _, m := range adi.Modules() {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// describe returns r as text, like "module google is disabled in
+// #random".
+func describe(r adi.Rule, tr adi.Transport) string {
+	target := "command " + r.Command
+	if r.Module != "" {
+		target = "module " + r.Module
+	}
+	state := "disabled"
+	if r.Enabled {
+		state = "enabled"
+	}
+	where := "everywhere"
+	if r.Channel != adi.AllChannels {
+		where = "in " + r.Channel
+		if c := adi.ResolveChannel(tr, r.Channel); c != nil {
+			where = "in #" + c.Name
+		}
+	}
+	return fmt.Sprintf("%s is %s %s", target, state, where)
+}
@@ -0,0 +1,47 @@
+package channels
+
+import (
+	"testing"
+
+	"github.com/henkman/slackbot/adi/aditest"
+	_ "github.com/henkman/slackbot/adi/module/points"
+)
+
+func TestRules(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 10)
+	w.AddChannel("CRANDOM", "random")
+	tests := []struct {
+		channel string
+		text    string
+		reply   string
+	}{
+		{"general", "rules", "everything is enabled everywhere"},
+		{"general", "disable pts random", "command pts is disabled in #random"},
+		{"random", "pts", "pts is disabled in this channel"},
+		{"general", "pts", "your points: 10"},
+		{"general", "disable --all --module points", "module points is disabled everywhere"},
+		{"general", "pts", "pts is disabled in this channel"},
+		{"general", "enable pts", "command pts is enabled in #general"},
+		{"general", "pts", "your points: 10"},
+		{"random", "pts", "pts is disabled in this channel"},
+		{"random", "cost", "cost is disabled in this channel"},
+		{"general", "disable enable", "enable can not be disabled"},
+		{"general", "disable --module channels", "channels can not be disabled"},
+		{"general", "disable nope", "nope is neither a command nor a module"},
+		{"general", "enable --all pts random", "either give a channel or --all"},
+		{"general", "rules", "command pts is disabled in #random\n" +
+			"command pts is enabled in #general\n" +
+			"module points is disabled everywhere"},
+	}
+	for _, test := range tests {
+		channel := aditest.Channel
+		if test.channel == "random" {
+			channel = "CRANDOM"
+		}
+		if r := w.SayIn("UALICE", channel, test.text); r != test.reply {
+			t.Errorf("%q in %s: expected reply %q, got %q",
+				test.text, test.channel, test.reply, r)
+		}
+	}
+}
@@ -2,116 +2,276 @@ package basics
 
 import (
 	"fmt"
-	"strconv"
+	"math"
+	"sort"
 	"strings"
 
 	"github.com/henkman/slackbot/adi"
-	"github.com/nlopes/slack"
 )
 
 func init() {
 
-	adi.RegisterFunc("lvl", func(m adi.Message, rtm *slack.RTM) adi.Response {
-		if m.Text == "" {
+	adi.RegisterCommand("lvl",
+		adi.Spec{
+			Description: "show the level of a user",
+			Args: []adi.Arg{
+				{Name: "user", Type: adi.ArgUser, Optional: true},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			adi.Lock()
+			defer adi.Unlock()
+			us := m.Args.User("user")
+			if us == nil {
+				return adi.Response{
+					Text:   fmt.Sprintf("your level: %d", m.User.Level),
+					Charge: true,
+				}
+			}
+			up := adi.GetCreateUser(us.ID)
 			return adi.Response{
-				Text:   fmt.Sprintf("your level: %d", m.User.Level),
+				Text:   fmt.Sprintf("%s level: %d", us.Name, up.Level),
 				Charge: true,
 			}
-		}
-		us := adi.GetUserByName(rtm, m.Text)
-		if us == nil {
+		})
+
+	adi.RegisterCommand("setlvl",
+		adi.Spec{
+			Description:   "set level of user",
+			RequiredLevel: adi.MaxLevel,
+			Args: []adi.Arg{
+				{Name: "user", Type: adi.ArgUser},
+				{Name: "level", Type: adi.ArgInt, Min: 0, Max: math.MaxUint8},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			if !adi.LockChange(m) {
+				return adi.Response{}
+			}
+			defer adi.Unlock()
+			us := m.Args.User("user")
+			up := adi.GetCreateUser(us.ID)
+			l := adi.Level(m.Args.Int("level"))
+			// nobody can give more than they have, so levels can
+			// only be handed down
+			if adi.IsOwner(us.ID) {
+				return adi.Response{
+					Text: fmt.Sprintf("%s is an owner", us.Name),
+				}
+			}
+			if up.Level >= m.User.Level {
+				return adi.Response{
+					Text: fmt.Sprintf("you can only change the level of users below your level %d",
+						m.User.Level),
+				}
+			}
+			if l >= m.User.Level {
+				return adi.Response{
+					Text: fmt.Sprintf("you can only give levels below your level %d",
+						m.User.Level),
+				}
+			}
+			adi.Audit(m, us.ID, fmt.Sprint(up.Level), fmt.Sprint(l))
+			up.Level = l
+			adi.Changed()
 			return adi.Response{
-				Text: "user not found",
+				Text: fmt.Sprintf("%s level is now %d",
+					us.Name, up.Level),
+				Charge: true,
 			}
-		}
-		up := adi.GetCreateUser(us.ID)
-		return adi.Response{
-			Text:   fmt.Sprintf("%s level: %d", us.Name, up.Level),
-			Charge: true,
-		}
-	})
+		})
 
-	adi.RegisterFunc("setlvl", func(m adi.Message, rtm *slack.RTM) adi.Response {
-		if m.Text == "" {
-			return adi.Response{
-				Text: "set level of user",
+	adi.RegisterCommand("rqlvl",
+		adi.Spec{
+			Description: "find out the required level of a command",
+			Args: []adi.Arg{
+				{Name: "command", Type: adi.ArgCommand},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			adi.RLock()
+			defer adi.RUnlock()
+			cmd := adi.GetCommandByName(m.Args.String("command"))
+			if cmd == nil {
+				return adi.Response{
+					Text: "command not found",
+				}
 			}
-		}
-		s := strings.Split(m.Text, " ")
-		if len(s) != 2 {
 			return adi.Response{
-				Text: "syntax: setlevel [user] [level]",
+				Text: fmt.Sprintf(
+					"%s requires level %d", cmd.Name, cmd.RequiredLevel),
+				Charge: true,
 			}
-		}
-		l, err := strconv.ParseUint(s[1], 10, 8)
-		if err != nil {
-			return adi.Response{
-				Text: "syntax: setlevel [user] [level]",
+		})
+
+	adi.RegisterCommand("setrqlvl",
+		adi.Spec{
+			Description:   "set required level for a command",
+			RequiredLevel: adi.MaxLevel,
+			Args: []adi.Arg{
+				{Name: "command", Type: adi.ArgCommand},
+				{Name: "level", Type: adi.ArgInt, Min: 0, Max: math.MaxUint8},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			if !adi.LockChange(m) {
+				return adi.Response{}
 			}
-		}
-		us := adi.GetUserByName(rtm, s[0])
-		if us == nil {
+			defer adi.Unlock()
+			cmd := adi.GetCommandByName(m.Args.String("command"))
+			if cmd == nil {
+				return adi.Response{
+					Text: "command not found",
+				}
+			}
+			l := adi.Level(m.Args.Int("level"))
+			if cmd.RequiredLevel > m.User.Level || l > m.User.Level {
+				return adi.Response{
+					Text: fmt.Sprintf("you can only change required levels up to your level %d",
+						m.User.Level),
+				}
+			}
+			adi.Audit(m, cmd.Name, fmt.Sprint(cmd.RequiredLevel), fmt.Sprint(l))
+			cmd.RequiredLevel = l
+			adi.Changed()
 			return adi.Response{
-				Text: "user not found",
+				Text: fmt.Sprintf("%s now requires level %d",
+					cmd.Name, cmd.RequiredLevel),
+				Charge: true,
 			}
-		}
-		up := adi.GetCreateUser(us.ID)
-		up.Level = adi.Level(l)
-		return adi.Response{
-			Text: fmt.Sprintf("%s level is now %d",
-				us.Name, up.Level),
-			Charge: true,
-		}
-	})
+		})
 
-	adi.RegisterFunc("rqlvl", func(m adi.Message, rtm *slack.RTM) adi.Response {
-		if m.Text == "" {
-			return adi.Response{
-				Text: "find out the required level of a command",
+	adi.RegisterCommand("grant",
+		adi.Spec{
+			Description:   "give a user a role",
+			RequiredLevel: adi.MaxLevel,
+			Args: []adi.Arg{
+				{Name: "user", Type: adi.ArgUser},
+				{Name: "role", Type: adi.ArgWord},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			if !adi.LockChange(m) {
+				return adi.Response{}
 			}
-		}
-		cmd := adi.GetCommandByName(m.Text)
-		if cmd == nil {
+			defer adi.Unlock()
+			us := m.Args.User("user")
+			role := m.Args.String("role")
+			if _, ok := adi.Roles[role]; !ok {
+				return adi.Response{
+					Text: fmt.Sprintf("unknown role %s", role),
+				}
+			}
+			if p := missingPermission(m.User, role); p != "" {
+				return adi.Response{
+					Text: fmt.Sprintf("you can not grant %s as you may not %s", role, p),
+				}
+			}
+			up := adi.GetCreateUser(us.ID)
+			if adi.HasRole(up, role) {
+				return adi.Response{
+					Text: fmt.Sprintf("%s already has role %s", us.Name, role),
+				}
+			}
+			before := strings.Join(up.Roles, ", ")
+			up.Roles = append(up.Roles, role)
+			adi.Changed()
+			adi.Audit(m, us.ID, before, strings.Join(up.Roles, ", "))
 			return adi.Response{
-				Text: "command not found",
+				Text:   fmt.Sprintf("%s now has role %s", us.Name, role),
+				Charge: true,
 			}
-		}
-		return adi.Response{
-			Text: fmt.Sprintf(
-				"%s requires level %d", m.Text, cmd.RequiredLevel),
-			Charge: true,
-		}
-	})
+		})
 
-	adi.RegisterFunc("setrqlvl", func(m adi.Message, rtm *slack.RTM) adi.Response {
-		if m.Text == "" {
-			return adi.Response{
-				Text: "set required level for a command",
+	adi.RegisterCommand("revoke",
+		adi.Spec{
+			Description:   "take a role from a user",
+			RequiredLevel: adi.MaxLevel,
+			Args: []adi.Arg{
+				{Name: "user", Type: adi.ArgUser},
+				{Name: "role", Type: adi.ArgWord},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			if !adi.LockChange(m) {
+				return adi.Response{}
 			}
-		}
-		s := strings.Split(m.Text, " ")
-		if len(s) != 2 {
-			return adi.Response{
-				Text: "syntax: setrqlvl [command] [level]",
+			defer adi.Unlock()
+			us := m.Args.User("user")
+			role := m.Args.String("role")
+			if p := missingPermission(m.User, role); p != "" {
+				return adi.Response{
+					Text: fmt.Sprintf("you can not revoke %s as you may not %s", role, p),
+				}
+			}
+			up := adi.GetCreateUser(us.ID)
+			for i, r := range up.Roles {
+				if r == role {
+					before := strings.Join(up.Roles, ", ")
+					up.Roles = append(up.Roles[:i], up.Roles[i+1:]...)
+					adi.Changed()
+					adi.Audit(m, us.ID, before, strings.Join(up.Roles, ", "))
+					return adi.Response{
+						Text:   fmt.Sprintf("%s no longer has role %s", us.Name, role),
+						Charge: true,
+					}
+				}
 			}
-		}
-		p, err := strconv.ParseUint(s[1], 10, 8)
-		if err != nil {
 			return adi.Response{
-				Text: "syntax: setrqlvl [command] [level]",
+				Text: fmt.Sprintf("%s does not have role %s", us.Name, role),
 			}
-		}
-		cmd := adi.GetCommandByName(s[0])
-		if cmd == nil {
+		})
+
+	adi.RegisterCommand("roles",
+		adi.Spec{
+			Description: "list the roles or the roles of a user",
+			Args: []adi.Arg{
+				{Name: "user", Type: adi.ArgUser, Optional: true},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			adi.Lock()
+			defer adi.Unlock()
+			if us := m.Args.User("user"); us != nil {
+				up := adi.GetCreateUser(us.ID)
+				if len(up.Roles) == 0 {
+					return adi.Response{
+						Text:   fmt.Sprintf("%s has no roles", us.Name),
+						Charge: true,
+					}
+				}
+				return adi.Response{
+					Text: fmt.Sprintf("%s roles: %s",
+						us.Name, strings.Join(up.Roles, ", ")),
+					Charge: true,
+				}
+			}
+			if len(adi.Roles) == 0 {
+				return adi.Response{
+					Text:   "there are no roles",
+					Charge: true,
+				}
+			}
+			lines := make([]string, 0, len(adi.Roles))
+			for name, perms := range adi.Roles {
+				lines = append(lines, fmt.Sprintf("%s: %s",
+					name, strings.Join(perms, ", ")))
+			}
+			sort.Strings(lines)
 			return adi.Response{
-				Text: "command not found",
+				Text:   strings.Join(lines, "\n"),
+				Charge: true,
 			}
+		})
+}
+
+// missingPermission returns a permission of role that u does not have,
+// so u can not hand out more than u may do. The state has to be locked.
+func missingPermission(u *adi.User, role string) string {
+	for _, p := range adi.Roles[role] {
+		if !adi.Can(u, p) {
+			return p
 		}
-		cmd.RequiredLevel = adi.Level(p)
-		return adi.Response{
-			Text: fmt.Sprintf("%s now requires level %d",
-				cmd.Name, cmd.RequiredLevel),
-			Charge: true,
-		}
-	})
+	}
+	return ""
 }
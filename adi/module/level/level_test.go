@@ -0,0 +1,163 @@
+package basics
+
+import (
+	"testing"
+
+	"github.com/henkman/slackbot/adi"
+	"github.com/henkman/slackbot/adi/aditest"
+	_ "github.com/henkman/slackbot/adi/module/points"
+)
+
+func TestLevel(t *testing.T) {
+	tests := []struct {
+		text  string
+		reply string
+	}{
+		{"lvl", "your level: 5"},
+		{"lvl bob", "bob level: 1"},
+		{"lvl carol", "user not found"},
+		{"lvl <@UBOB>", "bob level: 1"},
+		{"setlvl bob", "syntax: setlvl <user> <level>"},
+		{"setlvl bob 256", "level has to be between 0 and 255"},
+		{"setlvl", "set level of user\nsyntax: setlvl <user> <level>"},
+		{"setlvl bob 3", "bob level is now 3"},
+		{"rqlvl lvl", "lvl requires level 0"},
+		{"rqlvl nope", "command not found"},
+		{"setrqlvl lvl 2", "lvl now requires level 2"},
+	}
+	for _, test := range tests {
+		w := aditest.New()
+		w.AddUser("UALICE", "alice", 5, 0)
+		w.AddUser("UBOB", "bob", 1, 0)
+		if r := w.Say("UALICE", test.text); r != test.reply {
+			t.Errorf("%q: expected reply %q, got %q", test.text, test.reply, r)
+		}
+	}
+}
+
+func TestRequiredLevel(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 5, 0)
+	w.AddUser("UBOB", "bob", 1, 0)
+	adi.GetCommandByName("setlvl").RequiredLevel = 5
+	if r := w.Say("UBOB", "setlvl bob 5"); r != "unprivileged. your level: 1. required: 5" {
+		t.Errorf("unexpected reply %q", r)
+	}
+	if l := w.User("UBOB").Level; l != 1 {
+		t.Errorf("expected bob to stay at level 1, is %d", l)
+	}
+	if r := w.Say("UALICE", "setlvl bob 4"); r != "bob level is now 4" {
+		t.Errorf("unexpected reply %q", r)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UADMIN", "admin", adi.MaxLevel, 0)
+	w.AddUser("UBOB", "bob", 0, 0)
+	w.AddUser("UCAROL", "carol", 0, 10)
+	for _, name := range []string{"trpts", "setlvl", "setrqlvl", "grant", "revoke"} {
+		adi.GetCommandByName(name).RequiredLevel = adi.MaxLevel
+	}
+	adi.Roles = map[string][]string{
+		"bank":   {adi.ActionMovePoints},
+		"levels": {"setrqlvl"},
+	}
+	tests := []struct {
+		user  string
+		text  string
+		reply string
+	}{
+		{"UBOB", "trpts carol bob 5", "unprivileged. your level: 0. required: 255"},
+		{"UBOB", "grant bob bank", "unprivileged. your level: 0. required: 255"},
+		{"UADMIN", "grant bob bank", "bob now has role bank"},
+		{"UADMIN", "grant bob bank", "bob already has role bank"},
+		{"UADMIN", "grant bob nope", "unknown role nope"},
+		{"UBOB", "trpts carol bob 5", "carol points are now 5. bob points are now 5"},
+		{"UBOB", "setrqlvl lvl 0", "unprivileged. your level: 0. required: 255"},
+		{"UADMIN", "grant bob levels", "bob now has role levels"},
+		{"UBOB", "setrqlvl lvl 0", "lvl now requires level 0"},
+		{"UBOB", "setlvl bob 9", "unprivileged. your level: 0. required: 255"},
+		{"UBOB", "roles bob", "bob roles: bank, levels"},
+		{"UBOB", "roles carol", "carol has no roles"},
+		{"UBOB", "roles", "bank: move points\nlevels: setrqlvl"},
+		{"UADMIN", "revoke bob bank", "bob no longer has role bank"},
+		{"UADMIN", "revoke bob bank", "bob does not have role bank"},
+		{"UBOB", "trpts carol bob 5", "unprivileged. your level: 0. required: 255"},
+	}
+	for _, test := range tests {
+		if r := w.Say(test.user, test.text); r != test.reply {
+			t.Errorf("%s %q: expected reply %q, got %q",
+				test.user, test.text, test.reply, r)
+		}
+	}
+}
+
+func TestGrantEscalation(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UADMIN", "admin", adi.MaxLevel, 0)
+	w.AddUser("UBOB", "bob", 0, 0)
+	w.AddUser("UCAROL", "carol", 0, 0)
+	for _, name := range []string{"setrqlvl", "grant", "revoke"} {
+		adi.GetCommandByName(name).RequiredLevel = adi.MaxLevel
+	}
+	adi.Roles = map[string][]string{
+		"bank":    {adi.ActionMovePoints},
+		"levels":  {"setrqlvl"},
+		"granter": {"grant", "revoke"},
+		"all":     {adi.ActionMovePoints, adi.ActionManageProxies, "grant", "revoke"},
+	}
+	tests := []struct {
+		user  string
+		text  string
+		reply string
+	}{
+		{"UADMIN", "grant carol granter", "carol now has role granter"},
+		{"UADMIN", "grant bob levels", "bob now has role levels"},
+		{"UCAROL", "grant carol all", "you can not grant all as you may not move points"},
+		{"UCAROL", "grant bob bank", "you can not grant bank as you may not move points"},
+		{"UCAROL", "grant carol levels", "you can not grant levels as you may not setrqlvl"},
+		{"UCAROL", "revoke bob levels", "you can not revoke levels as you may not setrqlvl"},
+		{"UCAROL", "grant bob granter", "bob now has role granter"},
+		{"UCAROL", "revoke bob granter", "bob no longer has role granter"},
+	}
+	for _, test := range tests {
+		if r := w.Say(test.user, test.text); r != test.reply {
+			t.Errorf("%s %q: expected reply %q, got %q",
+				test.user, test.text, test.reply, r)
+		}
+	}
+}
+
+func TestEscalation(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UOWNER", "owner", adi.MaxLevel, 0)
+	w.AddUser("UADMIN", "admin", 200, 0)
+	w.AddUser("UMOD", "mod", 100, 0)
+	w.AddUser("UBOB", "bob", 1, 0)
+	adi.Owners = []string{"UOWNER"}
+	adi.GetCommandByName("rqlvl").RequiredLevel = 150
+	tests := []struct {
+		user  string
+		text  string
+		reply string
+	}{
+		{"UMOD", "setlvl bob 99", "bob level is now 99"},
+		{"UMOD", "setlvl bob 100", "you can only give levels below your level 100"},
+		{"UMOD", "setlvl mod 150", "you can only change the level of users below your level 100"},
+		{"UMOD", "setlvl admin 0", "you can only change the level of users below your level 100"},
+		{"UADMIN", "setlvl owner 0", "owner is an owner"},
+		{"UOWNER", "setlvl owner 0", "owner is an owner"},
+		{"UOWNER", "setlvl admin 254", "admin level is now 254"},
+		{"UMOD", "setrqlvl lvl 101", "you can only change required levels up to your level 100"},
+		{"UMOD", "setrqlvl rqlvl 0", "you can only change required levels up to your level 100"},
+		{"UMOD", "setrqlvl lvl 100", "lvl now requires level 100"},
+		{"UOWNER", "setrqlvl setrqlvl 255", "setrqlvl now requires level 255"},
+	}
+	for _, test := range tests {
+		if r := w.Say(test.user, test.text); r != test.reply {
+			t.Errorf("%s %q: expected reply %q, got %q",
+				test.user, test.text, test.reply, r)
+		}
+	}
+}
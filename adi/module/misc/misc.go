@@ -8,16 +8,18 @@ import (
 
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/alfredxing/calc/compute"
 	"github.com/dop251/goja"
 	"github.com/hako/durafmt"
 	"github.com/henkman/slackbot/adi"
-	"github.com/nlopes/slack"
 )
 
 var (
+	// vmMu guards vm, as a runtime can only run one script at a time.
+	vmMu      sync.Mutex
 	vm        *goja.Runtime
 	startTime time.Time
 )
@@ -43,12 +45,22 @@ func parseTimestamp(s string) (TimeStamp, error) {
 	}, nil
 }
 
+func visibility(visible bool) string {
+	if visible {
+		return "visible"
+	}
+	return "hidden"
+}
+
 func init() {
 
 	startTime = time.Now()
 
-	adi.RegisterFunc("uptime",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
+	adi.RegisterCommand("uptime",
+		adi.Spec{
+			Description: "show how long adi is running",
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
 			diff := time.Since(startTime)
 			return adi.Response{
 				Text:   durafmt.Parse(diff).String(),
@@ -56,8 +68,11 @@ func init() {
 			}
 		})
 
-	adi.RegisterFunc("ping",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
+	adi.RegisterCommand("ping",
+		adi.Spec{
+			Description: "show how long the message took to reach adi",
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
 			ts, err := parseTimestamp(m.Timestamp)
 			if err != nil {
 				return adi.Response{
@@ -72,14 +87,14 @@ func init() {
 			}
 		})
 
-	adi.RegisterFunc("cyrill",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			s := strings.TrimSpace(m.Text)
-			if s == "" {
-				return adi.Response{
-					Text: "prints latin script as cyrillic",
-				}
-			}
+	adi.RegisterCommand("cyrill",
+		adi.Spec{
+			Description: "prints latin script as cyrillic",
+			Args: []adi.Arg{
+				{Name: "text", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
 			cyrill := []struct {
 				Cyrillic string
 				Latin    string
@@ -118,7 +133,7 @@ func init() {
 				{"э", "ä"},
 				{"э", "v"},
 			}
-			s = strings.ToLower(s)
+			s := strings.ToLower(m.Args.String("text"))
 			l := len(s)
 			res := make([]byte, 0, l)
 			o := 0
@@ -142,8 +157,13 @@ func init() {
 			}
 		})
 
-	adi.RegisterFunc("hidden",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
+	adi.RegisterCommand("hidden",
+		adi.Spec{
+			Description: "list the hidden commands",
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			adi.RLock()
+			defer adi.RUnlock()
 			hidden := make([]string, 0, len(adi.Commands))
 			for _, c := range adi.Commands {
 				if !c.Visible {
@@ -157,41 +177,65 @@ func init() {
 			}
 		})
 
-	adi.RegisterFunc("delmsg",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if m.Text == "" {
+	adi.RegisterCommand("help",
+		adi.Spec{
+			Description: "list the commands or show how to use one",
+			Examples:    []string{"help", "help pts"},
+			Args: []adi.Arg{
+				{Name: "command", Type: adi.ArgCommand, Optional: true},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			adi.RLock()
+			defer adi.RUnlock()
+			if !m.Args.Has("command") {
 				return adi.Response{
-					Text: "delete message",
+					Text:   adi.HelpFor(m.User, m.Channel),
+					Charge: true,
 				}
 			}
-			s := strings.Split(m.Text, " ")
-			if len(s) != 2 {
+			cmd := adi.GetCommandByName(m.Args.String("command"))
+			if cmd == nil || !adi.Allowed(m.User, cmd) {
 				return adi.Response{
-					Text: "syntax: delmsg channel timestamp[,timestamp]",
+					Text: "command not found",
 				}
 			}
-			c := adi.GetChannelByName(rtm, s[0])
-			if c == nil {
-				return adi.Response{
-					Text: "channel not found",
-				}
+			return adi.Response{
+				Text:   adi.CommandHelp(cmd),
+				Charge: true,
 			}
-			ts := strings.Split(s[1], ",")
+		})
+
+	adi.RegisterCommand("delmsg",
+		adi.Spec{
+			Description:   "delete message",
+			RequiredLevel: adi.MaxLevel,
+			Args: []adi.Arg{
+				{Name: "channel", Type: adi.ArgChannel},
+				{Name: "timestamps", Type: adi.ArgWord},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			c := m.Args.Channel("channel")
+			ts := strings.Split(m.Args.String("timestamps"), ",")
 			for _, t := range ts {
 				t = strings.TrimSpace(t)
-				ts, err := parseTimestamp(m.Timestamp)
+				ts, err := parseTimestamp(t)
 				if err != nil {
 					return adi.Response{
 						Text: err.Error(),
 					}
 				}
-				if _, _, err := rtm.DeleteMessage(c.ID,
+				if err := tr.DeleteMessage(c.ID,
 					fmt.Sprintf("%d.%s", ts.Unix, ts.Unique)); err != nil {
 					log.Println("ERROR:", err)
 					return adi.Response{
 						Text: "couldn't delete",
 					}
 				}
+				adi.Lock()
+				adi.Audit(m, c.ID, t, "")
+				adi.Unlock()
 			}
 			return adi.Response{
 				Text:   "",
@@ -199,133 +243,209 @@ func init() {
 			}
 		})
 
-	adi.RegisterFunc("setvis",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if m.Text == "" {
-				return adi.Response{
-					Text: "set visiblity of a command",
-				}
-			}
-			s := strings.Split(m.Text, " ")
-			if len(s) != 2 {
-				return adi.Response{
-					Text: "syntax: setvis [command] [visible|hidden]",
-				}
-			}
-			cmd := adi.GetCommandByName(s[0])
+	adi.RegisterCommand("setvis",
+		adi.Spec{
+			Description:   "set visiblity of a command",
+			RequiredLevel: adi.MaxLevel,
+			Args: []adi.Arg{
+				{Name: "command", Type: adi.ArgCommand},
+				{Name: "visible|hidden", Type: adi.ArgWord},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			if !adi.LockChange(m) {
+				return adi.Response{}
+			}
+			defer adi.Unlock()
+			cmd := adi.GetCommandByName(m.Args.String("command"))
 			if cmd == nil {
 				return adi.Response{
 					Text: "command not found",
 				}
 			}
-			cmd.Visible = s[1] == "visible"
+			visible := m.Args.String("visible|hidden") == "visible"
+			adi.Audit(m, cmd.Name, visibility(cmd.Visible), visibility(visible))
+			cmd.Visible = visible
+			adi.Changed()
 			adi.ResetCommands()
 			return adi.Response{
-				Text:   fmt.Sprintf("%s now costs %d", cmd.Name, cmd.Price),
+				Text:   fmt.Sprintf("%s is now %s", cmd.Name, visibility(cmd.Visible)),
 				Charge: true,
 			}
 		})
 
-	adi.RegisterFunc("say",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if m.Text == "" {
+	adi.RegisterCommand("audit",
+		adi.Spec{
+			Description:   "list the last changes admins made by a user or to a command",
+			RequiredLevel: adi.MaxLevel,
+			Args: []adi.Arg{
+				{Name: "user|command", Type: adi.ArgWord, Optional: true},
+				{Name: "n", Type: adi.ArgInt, Optional: true, Min: 1, Max: 50},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			n := 10
+			if m.Args.Has("n") {
+				n = int(m.Args.Int("n"))
+			}
+			filter := m.Args.String("user|command")
+			if i, err := strconv.Atoi(filter); err == nil && !m.Args.Has("n") {
+				if i < 1 || i > 50 {
+					return adi.Response{
+						Text: "n has to be between 1 and 50",
+					}
+				}
+				n, filter = i, ""
+			}
+			match := func(e adi.AuditEntry) bool { return true }
+			if filter != "" {
+				if u := adi.ResolveUser(tr, filter); u != nil {
+					match = func(e adi.AuditEntry) bool {
+						if e.User == u.ID {
+							return true
+						}
+						for _, t := range strings.Split(e.Target, " -> ") {
+							if t == u.ID {
+								return true
+							}
+						}
+						return false
+					}
+				} else {
+					adi.RLock()
+					cmd := adi.GetCommandByName(filter)
+					adi.RUnlock()
+					if cmd == nil {
+						return adi.Response{
+							Text: "user or command not found",
+						}
+					}
+					match = func(e adi.AuditEntry) bool {
+						return e.Command == cmd.Name || e.Target == cmd.Name
+					}
+				}
+			}
+			adi.RLock()
+			defer adi.RUnlock()
+			es, err := adi.AuditEntries(match, n)
+			if err != nil {
+				log.Println("ERROR:", err)
+				return adi.Response{
+					Text: "internal error",
+				}
+			}
+			if len(es) == 0 {
 				return adi.Response{
-					Text: "says something",
+					Text:   "no changes",
+					Charge: true,
 				}
 			}
+			var b strings.Builder
+			for _, e := range es {
+				fmt.Fprintln(&b, e)
+			}
 			return adi.Response{
-				Text:   adi.UrlUnFurl(m.Text),
+				Text:   b.String(),
 				Charge: true,
 			}
 		})
 
-	adi.RegisterFunc("sayin",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if m.Text == "" {
-				return adi.Response{
-					Text: "says something in a channel",
-				}
-			}
-			s := strings.Index(m.Text, " ")
-			if s == -1 {
-				return adi.Response{
-					Text: "syntax: sayin channel something",
-				}
+	adi.RegisterCommand("say",
+		adi.Spec{
+			Description: "says something",
+			Args: []adi.Arg{
+				{Name: "text", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return adi.Response{
+				Text:   adi.UrlUnFurl(m.Args.String("text")),
+				Charge: true,
 			}
-			l := m.Text[:s]
-			t := adi.UrlUnFurl(m.Text[s:])
-			if ch := adi.GetChannelByName(rtm, l); ch != nil {
-				rtm.SendMessage(rtm.NewOutgoingMessage(
-					t, ch.ID))
+		})
+
+	adi.RegisterCommand("sayin",
+		adi.Spec{
+			Description: "says something in a channel",
+			Args: []adi.Arg{
+				{Name: "channel|user", Type: adi.ArgWord},
+				{Name: "text", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			l := m.Args.String("channel|user")
+			t := adi.UrlUnFurl(m.Args.String("text"))
+			if ch := adi.GetChannelByName(tr, l); ch != nil {
+				tr.SendMessage(ch.ID, t)
 				return adi.Response{
 					Text:   "",
 					Charge: true,
 				}
 			}
-			if ch := adi.GetGroupByName(rtm, l); ch != nil {
-				rtm.SendMessage(rtm.NewOutgoingMessage(
-					t, ch.ID))
+			if ch := adi.GetGroupByName(tr, l); ch != nil {
+				tr.SendMessage(ch.ID, t)
 				return adi.Response{
 					Text:   "",
 					Charge: true,
 				}
 			}
-			u := adi.GetUserByName(rtm, l)
+			u := adi.GetUserByName(tr, l)
 			if u == nil {
 				return adi.Response{
 					Text:   "did not find channel or user",
 					Charge: false,
 				}
 			}
-			imcs := adi.GetIMChannels(rtm, []string{u.ID})
-			if imcs == nil && len(imcs) > 0 {
+			imcs := adi.GetIMChannels(tr, []string{u.ID})
+			if len(imcs) == 0 {
 				return adi.Response{
 					Text:   "did not find channel or user",
 					Charge: false,
 				}
 			}
-			rtm.SendMessage(rtm.NewOutgoingMessage(
-				t, imcs[0].ID))
+			tr.SendMessage(imcs[0], t)
 			return adi.Response{
 				Text:   "",
 				Charge: true,
 			}
 		})
 
-	adi.RegisterFunc("id",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if m.Text == "" {
+	adi.RegisterCommand("id",
+		adi.Spec{
+			Description: "show the id of a user",
+			Args: []adi.Arg{
+				{Name: "user", Type: adi.ArgUser, Optional: true},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			us := m.Args.User("user")
+			if us == nil {
 				return adi.Response{
 					Text:   fmt.Sprintf("your id: %s", m.User.ID),
 					Charge: true,
 				}
 			}
-			us := adi.GetUserByName(rtm, m.Text)
-			if us == nil {
-				return adi.Response{
-					Text: "user not found",
-				}
-			}
 			return adi.Response{
 				Text:   fmt.Sprintf("%s id: %s", us.Name, us.ID),
 				Charge: true,
 			}
 		})
 
-	adi.RegisterFunc("calc",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if m.Text == "" {
-				return adi.Response{
-					Text: `a calculator
-	Usage:
-	  Operators: +, -, *, /, ^, %%
-	  Functions: sin, cos, tan, cot, sec, csc,
-	             asin, acos, atan, acot, asec,
-	             acsc, sqrt, log, lg, ln, abs
-	  Constants: e, pi, π`,
-				}
-			}
-			res, err := compute.Evaluate(m.Text)
+	adi.RegisterCommand("calc",
+		adi.Spec{
+			Description: `a calculator
+  Operators: +, -, *, /, ^, %
+  Functions: sin, cos, tan, cot, sec, csc,
+             asin, acos, atan, acot, asec,
+             acsc, sqrt, log, lg, ln, abs
+  Constants: e, pi, π`,
+			Args: []adi.Arg{
+				{Name: "expression", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			e := m.Args.String("expression")
+			res, err := compute.Evaluate(e)
 			if err != nil {
 				log.Println("ERROR:", err)
 				return adi.Response{
@@ -333,13 +453,16 @@ func init() {
 				}
 			}
 			return adi.Response{
-				Text:   fmt.Sprintf("%s=%g", m.Text, res),
+				Text:   fmt.Sprintf("%s=%g", e, res),
 				Charge: true,
 			}
 		})
 
-	adi.RegisterFunc("coin",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
+	adi.RegisterCommand("coin",
+		adi.Spec{
+			Description: "flip a coin",
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
 			var t string
 			if adi.RandBool() {
 				t = "heads"
@@ -352,14 +475,18 @@ func init() {
 			}
 		})
 
-	adi.RegisterFunc("js",
-		func(m adi.Message, rtm *slack.RTM) (r adi.Response) {
-			if m.Text == "" {
-				return adi.Response{
-					Text: "interactive javascript console. type reload to reload the VM",
-				}
-			}
-			if m.Text == "reload" {
+	adi.RegisterCommand("js",
+		adi.Spec{
+			Description: "interactive javascript console. type reload to reload the VM",
+			Args: []adi.Arg{
+				{Name: "script", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) (r adi.Response) {
+			script := m.Args.String("script")
+			vmMu.Lock()
+			defer vmMu.Unlock()
+			if script == "reload" {
 				vm = goja.New()
 				return adi.Response{
 					Text: "VM reloaded",
@@ -372,7 +499,7 @@ func init() {
 				Value goja.Value
 				Error error
 			}
-			done := make(chan Done)
+			done := make(chan Done, 1)
 			// NOTE: goja has problems with Exception.Error and .String
 			// so I recover the panic thrown in there until they fix it
 			defer func() {
@@ -384,13 +511,15 @@ func init() {
 				}
 			}()
 			go func() {
-				v, err := vm.RunString(m.Text)
+				v, err := vm.RunString(script)
 				done <- Done{v, err}
 			}()
 			t := time.NewTimer(time.Second)
 			select {
 			case <-t.C:
 				vm.Interrupt("halt")
+				// the script has to stop before the next one may run
+				<-done
 				r = adi.Response{
 					Text:   "script took too long to execute",
 					Charge: true,
@@ -412,14 +541,16 @@ func init() {
 			return
 		})
 
-	adi.RegisterFunc("rnd",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if m.Text == "" {
-				return adi.Response{
-					Text: "randomly prints one of the comma separated texts given",
-				}
-			}
-			c := strings.Split(m.Text, ",")
+	adi.RegisterCommand("rnd",
+		adi.Spec{
+			Description: "randomly prints one of the comma separated texts given",
+			Args: []adi.Arg{
+				{Name: "texts", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			c := strings.Split(m.Args.String("texts"), ",")
+
 			if len(c) == 1 {
 				return adi.Response{
 					Text:   strings.TrimSpace(c[0]),
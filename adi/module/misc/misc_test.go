@@ -2,8 +2,12 @@ package misc
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/henkman/slackbot/adi"
+	"github.com/henkman/slackbot/adi/aditest"
 )
 
 func TestTimeStamp(t *testing.T) {
@@ -16,3 +20,135 @@ func TestTimeStamp(t *testing.T) {
 	diff := time.Since(mt)
 	fmt.Println(diff, ts)
 }
+
+func TestMisc(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 0)
+	w.AddChannel("CRANDOM", "random")
+	tests := []struct {
+		text  string
+		reply string
+	}{
+		{"say hello world", "hello world"},
+		{"rnd only", "only"},
+		{"id", "your id: UALICE"},
+		{"id alice", "alice id: UALICE"},
+		{"id carol", "user not found"},
+		{"sayin nowhere hi", "did not find channel or user"},
+		{"setvis say hidden", "say is now hidden"},
+		{"hidden", "say"},
+		{"help id", "id: show the id of a user\nsyntax: id [user]\ncosts 0 points, requires level 0"},
+		{"rnd", "randomly prints one of the comma separated texts given\nsyntax: rnd <texts...>"},
+		{"help nope", "command not found"},
+	}
+	for _, test := range tests {
+		if r := w.Say("UALICE", test.text); r != test.reply {
+			t.Errorf("%q: expected reply %q, got %q", test.text, test.reply, r)
+		}
+	}
+}
+
+func TestSayin(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 0)
+	w.AddChannel("CRANDOM", "random")
+	tests := []struct {
+		text    string
+		channel string
+		reply   string
+	}{
+		{"sayin random hi", "CRANDOM", "hi"},
+		{"sayin  <#CRANDOM|random>   hi  there", "CRANDOM", "hi  there"},
+		{"sayin alice psst", "DUALICE", "psst"},
+	}
+	for _, test := range tests {
+		ms := w.Send("UALICE", aditest.Channel, "<@"+aditest.BotID+"> "+test.text)
+		if len(ms) != 1 || ms[0].Channel != test.channel || ms[0].Text != test.reply {
+			t.Errorf("%q: expected %q in %s, got %v",
+				test.text, test.reply, test.channel, ms)
+		}
+	}
+}
+
+func TestHelp(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 0)
+	w.AddUser("UBOB", "bob", 5, 0)
+	adi.Lock()
+	say := adi.GetCommandByName("say")
+	say.Price = 2
+	say.Examples = []string{"say hello"}
+	sayin := adi.GetCommandByName("sayin")
+	sayin.RequiredLevel = 5
+	sayin.Description = "talk somewhere else"
+	adi.GetCommandByName("coin").Visible = false
+	adi.Unlock()
+	tests := []struct {
+		user  string
+		text  string
+		reply string
+	}{
+		{"UALICE", "help say", "say: says something\nsyntax: say <text...>\ncosts 2 points, requires level 0\nexamples:\n  say hello"},
+		{"UALICE", "help sayin", "command not found"},
+		{"UBOB", "help sayin", "sayin: talk somewhere else\nsyntax: sayin <channel|user> <text...>\ncosts 0 points, requires level 5"},
+	}
+	for _, test := range tests {
+		if r := w.Say(test.user, test.text); r != test.reply {
+			t.Errorf("%q: expected reply %q, got %q", test.text, test.reply, r)
+		}
+	}
+	r := w.Say("UALICE", "help")
+	if !strings.HasPrefix(r, "misc: ") || !strings.Contains(r, "say (2 points)") ||
+		strings.Contains(r, "sayin") || strings.Contains(r, "coin") {
+		t.Errorf("unexpected help for alice %q", r)
+	}
+	if r := w.Say("UBOB", "help"); !strings.Contains(r, "sayin (level 5)") {
+		t.Errorf("unexpected help for bob %q", r)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", adi.MaxLevel, 0)
+	w.AddUser("UBOB", "bob", 0, 0)
+	w.Say("UALICE", "setvis say hidden")
+	w.Say("UALICE", "setvis rnd hidden")
+	w.Say("UALICE", "setvis say visible")
+	w.Say("UBOB", "audit")
+	tests := []struct {
+		text  string
+		reply []string
+	}{
+		{"audit", []string{
+			"#3 alice setvis say: hidden -> visible",
+			"#2 alice setvis rnd: visible -> hidden",
+			"#1 alice setvis say: visible -> hidden",
+		}},
+		{"audit 1", []string{"#3 alice setvis say: hidden -> visible"}},
+		{"audit say", []string{
+			"#3 alice setvis say: hidden -> visible",
+			"#1 alice setvis say: visible -> hidden",
+		}},
+		{"audit alice 2", []string{
+			"#3 alice setvis say: hidden -> visible",
+			"#2 alice setvis rnd: visible -> hidden",
+		}},
+		{"audit bob", []string{"no changes"}},
+		{"audit id", []string{"no changes"}},
+		{"audit nobody", []string{"user or command not found"}},
+	}
+	for _, test := range tests {
+		r := w.Say("UALICE", test.text)
+		var lines []string
+		for _, l := range strings.Split(strings.TrimSpace(r), "\n") {
+			// leave out the time and channel
+			if fs := strings.Fields(l); strings.HasPrefix(l, "#") {
+				l = strings.Join(append(fs[:1], fs[4:len(fs)-1]...), " ")
+			}
+			lines = append(lines, l)
+		}
+		if got := strings.Join(lines, "\n"); got != strings.Join(test.reply, "\n") {
+			t.Errorf("%q: expected reply %q, got %q", test.text, test.reply, r)
+		}
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"strings"
 
 	"github.com/henkman/slackbot/adi"
-	"github.com/nlopes/slack"
 )
 
 type UsersByRank []adi.User
@@ -20,26 +19,39 @@ func (a UsersByRank) Less(i, j int) bool { return a[i].Points > a[j].Points }
 
 func init() {
 
-	adi.RegisterFunc("rank",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
+	adi.RegisterCommand("rank",
+		adi.Spec{
+			Description: "list users by points",
+			Flags: []adi.Flag{
+				{Name: "top", Value: "n"},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			top := -1
+			if v, ok := m.Args.Flag("top"); ok {
+				n, err := strconv.ParseUint(v, 10, 16)
+				if err != nil || n == 0 {
+					return adi.Response{
+						Text: "top has to be a positive number",
+					}
+				}
+				top = int(n)
+			}
+			adi.RLock()
 			us := make([]adi.User, len(adi.Users))
-			copy(us, adi.Users)
+			for i, u := range adi.Users {
+				us[i] = *u
+			}
+			adi.RUnlock()
 			sort.Sort(UsersByRank(us))
-			sus, err := rtm.GetUsers()
-			if err != nil {
-				log.Println("ERROR:", err.Error())
-				return adi.Response{
-					Text: "internal error",
-				}
+			if top != -1 && top < len(us) {
+				us = us[:top]
 			}
 			var s strings.Builder
 			for i, o := range us {
-				for _, su := range sus {
-					if su.ID == o.ID {
-						fmt.Fprintf(&s, "%d. %s@%d\n",
-							i+1, su.Name, o.Points)
-						break
-					}
+				if su := adi.ChatUserByID(o.ID); su != nil {
+					fmt.Fprintf(&s, "%d. %s@%d\n",
+						i+1, su.Name, o.Points)
 				}
 			}
 			return adi.Response{
@@ -48,105 +60,92 @@ func init() {
 			}
 		})
 
-	adi.RegisterFunc("setprc",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if m.Text == "" {
-				return adi.Response{
-					Text: "set price of a command",
-				}
-			}
-			s := strings.Split(m.Text, " ")
-			if len(s) != 2 {
-				return adi.Response{
-					Text: "syntax: setprc [command] [price]",
-				}
-			}
-			p, err := strconv.ParseUint(s[1], 10, 64)
-			if err != nil {
-				return adi.Response{
-					Text: "syntax: setprc [command] [price]",
-				}
-			}
-			cmd := adi.GetCommandByName(s[0])
+	adi.RegisterCommand("setprc",
+		adi.Spec{
+			Description:   "set price of a command",
+			RequiredLevel: adi.MaxLevel,
+			Args: []adi.Arg{
+				{Name: "command", Type: adi.ArgCommand},
+				{Name: "price", Type: adi.ArgInt, Min: 0, Max: math.MaxInt64},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			if !adi.LockChange(m) {
+				return adi.Response{}
+			}
+			defer adi.Unlock()
+			cmd := adi.GetCommandByName(m.Args.String("command"))
 			if cmd == nil {
 				return adi.Response{
 					Text: "command not found",
 				}
 			}
-			cmd.Price = adi.Points(p)
+			p := adi.Points(m.Args.Int("price"))
+			adi.Audit(m, cmd.Name, fmt.Sprint(cmd.Price), fmt.Sprint(p))
+			cmd.Price = p
+			adi.Changed()
 			return adi.Response{
 				Text:   fmt.Sprintf("%s now costs %d", cmd.Name, cmd.Price),
 				Charge: true,
 			}
 		})
 
-	adi.RegisterFunc("givepts",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if m.Text == "" {
-				return adi.Response{
-					Text: "give points to user",
-				}
-			}
-			s := strings.Split(m.Text, " ")
-			if len(s) != 2 {
-				return adi.Response{
-					Text: "syntax: givepts [user] [points|all]",
-				}
-			}
-			var src, dst adi.Account
-			src = &m.User.Points
-			n, err := adi.ParsePoints(src, "", s[1])
+	adi.RegisterCommand("givepts",
+		adi.Spec{
+			Description: "give points to user",
+			Args: []adi.Arg{
+				{Name: "user", Type: adi.ArgAccount},
+				{Name: "points", Type: adi.ArgPoints},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			if !adi.LockChange(m) {
+				return adi.Response{}
+			}
+			defer adi.Unlock()
+			name := m.Args.String("user")
+			src := adi.UserAccount(m.User)
+			n, err := adi.ParsePoints(src, "", m.Args.String("points"))
 			if err != "" {
 				return adi.Response{
 					Text: err,
 				}
 			}
-			dst = adi.GetAccountByName(rtm, s[0])
-			if dst == nil {
-				return adi.Response{
-					Text: "user not found",
-				}
-			}
+			dst := m.Args.Account("user")
 			if src == dst {
 				return adi.Response{
 					Text: "can't give points to yourself",
 				}
 			}
-			src.Sub(n)
-			dst.Add(n)
+			adi.Transfer(src, dst, n, "givepts", m.Timestamp)
 			return adi.Response{
 				Text: fmt.Sprintf("%s points %d. your points: %d",
-					s[0], dst.Balance(), src.Balance()),
+					name, dst.Balance(), src.Balance()),
 				Charge: true,
 			}
 		})
 
-	adi.RegisterFunc("duel",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if m.Text == "" {
+	adi.RegisterCommand("duel",
+		adi.Spec{
+			Description: "challenge somebody to get their points",
+			Args: []adi.Arg{
+				{Name: "user", Type: adi.ArgAccount},
+				{Name: "points", Type: adi.ArgPoints},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			if !adi.LockChange(m) {
+				return adi.Response{}
+			}
+			defer adi.Unlock()
+			name := m.Args.String("user")
+			if name == "pot" {
 				return adi.Response{
-					Text: "challenge somebody to get their points",
-				}
-			}
-			s := strings.Split(m.Text, " ")
-			if len(s) != 2 {
-				return adi.Response{
-					Text: "syntax: duel [user] [points|all]",
-				}
-			}
-			if s[0] == "pot" {
-				return adi.Response{
-					Text: fmt.Sprintf("can't duel %s", s[0]),
-				}
-			}
-			var src, dst adi.Account
-			src = &m.User.Points
-			dst = adi.GetAccountByName(rtm, s[0])
-			if dst == nil {
-				return adi.Response{
-					Text: "user not found",
+					Text: fmt.Sprintf("can't duel %s", name),
 				}
 			}
+			src := adi.UserAccount(m.User)
+			dst := m.Args.Account("user")
 			if src == dst {
 				return adi.Response{
 					Text: "can't duel yourself",
@@ -154,11 +153,11 @@ func init() {
 			}
 			if dst.Balance() == 0 {
 				return adi.Response{
-					Text: fmt.Sprintf("%s has no points", s[0]),
+					Text: fmt.Sprintf("%s has no points", name),
 				}
 			}
 			var n adi.Points
-			if s[1] == "all" {
+			if p := m.Args.String("points"); p == "all" {
 				if src.Balance() == 0 {
 					return adi.Response{
 						Text: "you have no points",
@@ -170,17 +169,7 @@ func init() {
 					n = src.Balance()
 				}
 			} else {
-				t, err := strconv.ParseUint(s[1], 10, 64)
-				if err != nil {
-					return adi.Response{
-						Text: "syntax: duel [user] [points|all]",
-					}
-				}
-				if t == 0 {
-					return adi.Response{
-						Text: "Must be more than 0 points",
-					}
-				}
+				t, _ := strconv.ParseUint(p, 10, 64)
 				if adi.Points(t) > src.Balance() {
 					return adi.Response{
 						Text: fmt.Sprintf(
@@ -192,22 +181,20 @@ func init() {
 					return adi.Response{
 						Text: fmt.Sprintf(
 							"%s does not have enough points. %s points: %d",
-							s[0], s[0], dst.Balance()),
+							name, name, dst.Balance()),
 					}
 				}
 				n = adi.Points(t)
 			}
 			var t string
 			if adi.RandBool() {
-				src.Add(n)
-				dst.Sub(n)
+				adi.Transfer(dst, src, n, "duel", m.Timestamp)
 				t = fmt.Sprintf("you took %d points. your points: %d. %s points: %d",
-					n, src.Balance(), s[0], dst.Balance())
+					n, src.Balance(), name, dst.Balance())
 			} else {
-				src.Sub(n)
-				dst.Add(n)
+				adi.Transfer(src, dst, n, "duel", m.Timestamp)
 				t = fmt.Sprintf("you lost %d points. your points: %d. %s points: %d",
-					n, src.Balance(), s[0], dst.Balance())
+					n, src.Balance(), name, dst.Balance())
 			}
 			return adi.Response{
 				Text:   t,
@@ -215,92 +202,244 @@ func init() {
 			}
 		})
 
-	adi.RegisterFunc("pts",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if m.Text == "" {
+	adi.RegisterCommand("pts",
+		adi.Spec{
+			Description: "show the points of a user",
+			Args: []adi.Arg{
+				{Name: "user", Type: adi.ArgAccount, Optional: true},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			adi.Lock()
+			defer adi.Unlock()
+			if !m.Args.Has("user") {
 				return adi.Response{
 					Text:   fmt.Sprintf("your points: %d", m.User.Points),
 					Charge: true,
 				}
 			}
-			src := adi.GetAccountByName(rtm, m.Text)
-			if src == nil {
+			return adi.Response{
+				Text: fmt.Sprintf("%s points: %d",
+					m.Args.String("user"), m.Args.Account("user").Balance()),
+				Charge: true,
+			}
+		})
+
+	adi.RegisterCommand("trpts",
+		adi.Spec{
+			Description:   "transfer points",
+			RequiredLevel: adi.MaxLevel,
+			Action:        adi.ActionMovePoints,
+			Args: []adi.Arg{
+				{Name: "src", Type: adi.ArgAccount},
+				{Name: "dst", Type: adi.ArgAccount},
+				{Name: "points", Type: adi.ArgPoints},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			if !adi.LockChange(m) {
+				return adi.Response{}
+			}
+			defer adi.Unlock()
+			sname, dname := m.Args.String("src"), m.Args.String("dst")
+			src := m.Args.Account("src")
+			n, err := adi.ParsePoints(src, sname, m.Args.String("points"))
+			if err != "" {
 				return adi.Response{
-					Text: "user not found",
+					Text: err,
 				}
 			}
+			dst := m.Args.Account("dst")
+			if src == dst {
+				return adi.Response{
+					Text: "source and destination can not be the same",
+				}
+			}
+			before := fmt.Sprintf("%d, %d", src.Balance(), dst.Balance())
+			adi.Transfer(src, dst, n, "trpts", m.Timestamp)
+			adi.Audit(m, src.AccountID()+" -> "+dst.AccountID(), before,
+				fmt.Sprintf("%d, %d", src.Balance(), dst.Balance()))
 			return adi.Response{
-				Text:   fmt.Sprintf("%s points: %d", m.Text, src.Balance()),
+				Text: fmt.Sprintf("%s points are now %d. %s points are now %d",
+					sname, src.Balance(), dname, dst.Balance()),
 				Charge: true,
 			}
 		})
 
-	adi.RegisterFunc("trpts",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if m.Text == "" {
+	adi.RegisterCommand("history",
+		adi.Spec{
+			Description: "list the last transactions of a user",
+			Args: []adi.Arg{
+				{Name: "user", Type: adi.ArgAccount, Optional: true},
+				{Name: "n", Type: adi.ArgInt, Optional: true, Min: 1, Max: 50},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			adi.Lock()
+			defer adi.Unlock()
+			n := 10
+			if m.Args.Has("n") {
+				n = int(m.Args.Int("n"))
+			}
+			acc := adi.UserAccount(m.User)
+			if m.Args.Has("user") {
+				acc = m.Args.Account("user")
+			}
+			txs, err := adi.History(acc.AccountID(), n)
+			if err != nil {
+				log.Println("ERROR:", err)
 				return adi.Response{
-					Text: "transfer points",
+					Text: "internal error",
 				}
 			}
-			s := strings.Split(m.Text, " ")
-			if len(s) != 3 {
+			if len(txs) == 0 {
 				return adi.Response{
-					Text: "syntax: trpts [src] [dst] [points|all]",
+					Text:   "no transactions",
+					Charge: true,
 				}
 			}
-			src := adi.GetAccountByName(rtm, s[0])
-			if src == nil {
+			var b strings.Builder
+			for _, tx := range txs {
+				fmt.Fprintf(&b, "#%d %s %s -> %s %d (%s)\n",
+					tx.ID, tx.Time.Format("02.Jan 15:04 MST"),
+					adi.NameOf(tx.Src), adi.NameOf(tx.Dst), tx.Amount, tx.Reason)
+			}
+			return adi.Response{
+				Text:   b.String(),
+				Charge: true,
+			}
+		})
+
+	adi.RegisterCommand("reverse",
+		adi.Spec{
+			Description:   "reverse a transaction",
+			RequiredLevel: adi.MaxLevel,
+			Action:        adi.ActionMovePoints,
+			Args: []adi.Arg{
+				{Name: "txid", Type: adi.ArgWord},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			id, err := strconv.ParseUint(
+				strings.TrimPrefix(m.Args.String("txid"), "#"), 10, 64)
+			if err != nil {
 				return adi.Response{
-					Text: fmt.Sprintf("user %s not found", s[0]),
+					Text: "txid has to be a number",
 				}
 			}
-			n, err := adi.ParsePoints(src, s[0], s[2])
-			if err != "" {
+			if !adi.LockChange(m) {
+				return adi.Response{}
+			}
+			defer adi.Unlock()
+			tx, err := adi.Reverse(id, m.Timestamp)
+			if err != nil {
 				return adi.Response{
-					Text: err,
+					Text: err.Error(),
 				}
 			}
-			dst := adi.GetAccountByName(rtm, s[1])
-			if dst == nil {
+			log.Printf("%s reversed transaction #%d as #%d", m.User.ID, id, tx.ID)
+			return adi.Response{
+				Text: fmt.Sprintf("moved %d points back from %s to %s as #%d",
+					tx.Amount, adi.NameOf(tx.Src), adi.NameOf(tx.Dst), tx.ID),
+				Charge: true,
+			}
+		})
+
+	adi.RegisterCommand("chkledger",
+		adi.Spec{
+			Description: "check that all balances match the ledger",
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			adi.RLock()
+			defer adi.RUnlock()
+			diffs, err := adi.AuditLedger()
+			if err != nil {
+				log.Println("ERROR:", err)
 				return adi.Response{
-					Text: fmt.Sprintf("user %s not found", s[1]),
+					Text: "internal error",
 				}
 			}
-			if src == dst {
+			if len(diffs) == 0 {
 				return adi.Response{
-					Text: "source and destination can not be the same",
+					Text:   "all balances match the ledger",
+					Charge: true,
 				}
 			}
-			src.Sub(n)
-			dst.Add(n)
 			return adi.Response{
-				Text: fmt.Sprintf("%s points are now %d. %s points are now %d",
-					s[0], src.Balance(), s[1], dst.Balance()),
+				Text:   strings.Join(diffs, "\n"),
 				Charge: true,
 			}
 		})
 
-	adi.RegisterFunc("cost",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if m.Text == "" {
-				return adi.Response{
-					Text: "find out the price of a command",
-				}
+	adi.RegisterCommand("salary",
+		adi.Spec{
+			Description: "show the salary a user earned today",
+			Args: []adi.Arg{
+				{Name: "user", Type: adi.ArgUser, Optional: true},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			adi.Lock()
+			defer adi.Unlock()
+			sal := &adi.GlobalBank.Salary
+			var t string
+			if us := m.Args.User("user"); us != nil {
+				t = fmt.Sprintf("%s earned %d points today",
+					us.Name, adi.EarnedToday(us.ID))
+			} else {
+				t = fmt.Sprintf("you earned %d points today",
+					adi.EarnedToday(m.User.ID))
+			}
+			var per string
+			if sal.Mode == adi.SalaryActivity {
+				per = "message"
+			} else {
+				per = "active " + sal.Every.String()
+			}
+			t += fmt.Sprintf(". salary: %d per %s", sal.Rate, per)
+			if sal.DailyCap > 0 {
+				t += fmt.Sprintf(", at most %d a day", sal.DailyCap)
 			}
-			cmd := adi.GetCommandByName(m.Text)
+			return adi.Response{
+				Text:   t,
+				Charge: true,
+			}
+		})
+
+	adi.RegisterCommand("cost",
+		adi.Spec{
+			Description: "find out the price of a command",
+			Args: []adi.Arg{
+				{Name: "command", Type: adi.ArgCommand},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			adi.RLock()
+			defer adi.RUnlock()
+			cmd := adi.GetCommandByName(m.Args.String("command"))
 			if cmd == nil {
 				return adi.Response{
 					Text: "command not found",
 				}
 			}
 			return adi.Response{
-				Text:   fmt.Sprintf("%s costs %d", m.Text, cmd.Price),
+				Text:   fmt.Sprintf("%s costs %d", cmd.Name, cmd.Price),
 				Charge: true,
 			}
 		})
 
-	adi.RegisterFunc("lottery",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
+	adi.RegisterCommand("lottery",
+		adi.Spec{
+			Description: "buy lottery tickets",
+			Args: []adi.Arg{
+				{Name: "tickets|all|info|help", Type: adi.ArgWord, Optional: true},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			if !adi.LockChange(m) {
+				return adi.Response{}
+			}
+			defer adi.Unlock()
 			lot := &adi.GlobalBank.Lottery
 			if m.Text == "" {
 				return adi.Response{
@@ -344,7 +483,7 @@ use 'lottery [tickets|all]' to buy tickets, 'lottery info' to get infos`,
 					Text: t,
 				}
 			}
-			var src adi.Account = &m.User.Points
+			src := adi.UserAccount(m.User)
 			var n uint64
 			{
 				if m.Text == "all" {
@@ -393,8 +532,8 @@ use 'lottery [tickets|all]' to buy tickets, 'lottery info' to get infos`,
 				lot.Tickets[m.User.ID] = n
 			}
 			lot.TicketsSold += n
-			src.Sub(p)
-			lot.Pot.Add(p)
+			adi.Changed()
+			adi.Transfer(src, adi.PotAccount(), p, "lottery", m.Timestamp)
 			return adi.Response{
 				Text: fmt.Sprintf("you bought %d tickets for %d. your points:%d. pot: %d",
 					n, p, src.Balance(), lot.Pot.Balance(),
@@ -0,0 +1,257 @@
+package points
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/henkman/slackbot/adi"
+	"github.com/henkman/slackbot/adi/aditest"
+)
+
+func TestPoints(t *testing.T) {
+	tests := []struct {
+		text  string
+		reply string
+		alice adi.Points
+		bob   adi.Points
+	}{
+		{"pts", "your points: 100", 100, 50},
+		{"pts bob", "bob points: 50", 100, 50},
+		{"pts carol", "user not found", 100, 50},
+		{"givepts bob", "syntax: givepts <user> <points|all>", 100, 50},
+		{"givepts <@UBOB> 10", "bob points 60. your points: 90", 90, 60},
+		{"givepts bob 0", "points have to be positive", 100, 50},
+		{"givepts bob 101", "you do not have enough points. you have 100", 100, 50},
+		{"givepts alice 10", "can't give points to yourself", 100, 50},
+		{"givepts bob 10", "bob points 60. your points: 90", 90, 60},
+		{"givepts bob all", "bob points 150. your points: 0", 0, 150},
+		{"trpts bob alice 20", "bob points are now 30. alice points are now 120", 120, 30},
+		{"trpts bob bank all", "bob points are now 0. bank points are now 50", 100, 0},
+		{"duel bob 200", "not enough points. your points: 100", 100, 50},
+		{"duel pot 1", "can't duel pot", 100, 50},
+	}
+	for _, test := range tests {
+		w := aditest.New()
+		w.AddUser("UALICE", "alice", 0, 100)
+		w.AddUser("UBOB", "bob", 0, 50)
+		if r := w.Say("UALICE", test.text); r != test.reply {
+			t.Errorf("%q: expected reply %q, got %q", test.text, test.reply, r)
+		}
+		if p := w.User("UALICE").Points; p != test.alice {
+			t.Errorf("%q: expected alice to have %d, got %d", test.text, test.alice, p)
+		}
+		if p := w.User("UBOB").Points; p != test.bob {
+			t.Errorf("%q: expected bob to have %d, got %d", test.text, test.bob, p)
+		}
+	}
+}
+
+func TestPrice(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 3)
+	adi.GetCommandByName("pts").Price = 2
+	if r := w.Say("UALICE", "pts"); r != "your points: 3" {
+		t.Errorf("unexpected reply %q", r)
+	}
+	if p := w.User("UALICE").Points; p != 1 {
+		t.Errorf("expected alice to be charged, has %d", p)
+	}
+	if r := w.Say("UALICE", "pts"); r != "not enough points. your points: 1. required: 2" {
+		t.Errorf("unexpected reply %q", r)
+	}
+	if adi.GlobalBank.Points != 2 {
+		t.Errorf("expected price to go to the bank, bank has %d", adi.GlobalBank.Points)
+	}
+}
+
+func TestLottery(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 10)
+	adi.GlobalBank.Lottery.TicketPrice = 3
+	if r := w.Say("UALICE", "lottery all"); r != "you bought 3 tickets for 9. your points:1. pot: 9" {
+		t.Errorf("unexpected reply %q", r)
+	}
+	if r := w.Say("UALICE", "lottery info"); r != "you have 3 tickets. 0 other users bought 0 tickets" {
+		t.Errorf("unexpected reply %q", r)
+	}
+}
+
+func TestConcurrentTransfers(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 1000)
+	w.AddUser("UBOB", "bob", 0, 1000)
+	adi.GetCommandByName("duel").Price = 1
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			adi.HandleMessage(w.Transport, aditest.Channel, "UALICE",
+				"<@"+aditest.BotID+"> givepts bob 3", "1.1")
+		}()
+		go func() {
+			defer wg.Done()
+			adi.HandleMessage(w.Transport, aditest.Channel, "UBOB",
+				"<@"+aditest.BotID+"> duel alice 5", "1.2")
+		}()
+	}
+	wg.Wait()
+	total := w.User("UALICE").Points + w.User("UBOB").Points +
+		adi.GlobalBank.Points
+	if total != 2000 {
+		t.Errorf("expected 2000 points in total, got %d", total)
+	}
+	if adi.GlobalBank.Points != 50 {
+		t.Errorf("expected bank to get 50 points for duels, got %d",
+			adi.GlobalBank.Points)
+	}
+}
+
+func TestLedger(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 100)
+	w.AddUser("UBOB", "bob", 0, 50)
+	adi.GetCommandByName("givepts").Price = 1
+	w.Say("UALICE", "givepts bob 10")
+	w.Say("UBOB", "trpts bob bank 5")
+	tests := []struct {
+		text  string
+		reply string
+	}{
+		{"history alice 2", "#4 bank 1 (givepts)\n#3 bob 10 (givepts)"},
+		{"history bob 1", "#5 bank 5 (trpts)"},
+		{"history bob x 1", "n has to be a number"},
+		{"history 0", "n has to be between 1 and 50"},
+		{"chkledger", "all balances match the ledger"},
+	}
+	for _, test := range tests {
+		r := w.Say("UALICE", test.text)
+		var b strings.Builder
+		for _, l := range strings.Split(strings.TrimSpace(r), "\n") {
+			// drop time and source
+			if f := strings.Fields(l); strings.HasPrefix(l, "#") {
+				l = strings.Join(append(f[:1], f[len(f)-3:]...), " ")
+			}
+			if b.Len() > 0 {
+				b.WriteByte('\n')
+			}
+			b.WriteString(l)
+		}
+		if b.String() != test.reply {
+			t.Errorf("%q: expected reply %q, got %q", test.text, test.reply, r)
+		}
+	}
+	adi.Lock()
+	adi.GlobalBank.Points = 1000
+	adi.Unlock()
+	if r := w.Say("UALICE", "chkledger"); r != "bank has 1000 points, ledger says 6" {
+		t.Errorf("unexpected reply %q", r)
+	}
+}
+
+func TestReverse(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 100)
+	w.AddUser("UBOB", "bob", 0, 50)
+	w.Say("UALICE", "givepts bob 10")
+	w.Say("UBOB", "givepts alice 55")
+	tests := []struct {
+		text  string
+		reply string
+	}{
+		{"reverse x", "txid has to be a number"},
+		{"reverse 9", "transaction #9 not found"},
+		{"reverse 0", "transaction #0 not found"},
+		{"reverse 1", "transaction #1 is a deposit"},
+		{"reverse 4", "moved 55 points back from alice to bob as #5"},
+		{"reverse #4", "transaction #4 was already reversed"},
+		{"givepts bob all", "bob points 150. your points: 0"},
+		{"reverse 3", "moved 10 points back from bob to alice as #7"},
+		{"chkledger", "all balances match the ledger"},
+	}
+	for _, test := range tests {
+		if r := w.Say("UALICE", test.text); r != test.reply {
+			t.Errorf("%q: expected reply %q, got %q", test.text, test.reply, r)
+		}
+	}
+	w.Say("UBOB", "givepts alice all")
+	if r := w.Say("UALICE", "reverse 6"); r != "bob has only 0 points" {
+		t.Errorf("unexpected reply %q", r)
+	}
+}
+
+func TestSalary(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour * 24).Add(time.Hour * 12)
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 0)
+	w.AddUser("UBOB", "bob", 5, 0)
+	w.AddUser("UCAROL", "carol", 0, 0)
+	w.AddChannel("CSPAM", "spam")
+	w.SetPresence("UCAROL", "away")
+	adi.Lock()
+	adi.Deposit(adi.BankAccount(), 100, "test")
+	sal := &adi.GlobalBank.Salary
+	sal.Rate = 2
+	sal.Every = time.Minute
+	sal.DailyCap = 5
+	sal.LevelMultipliers = map[adi.Level]float64{0: 1, 5: 1.5}
+	adi.Unlock()
+	w.Tick(now)
+	w.Tick(now.Add(time.Second * 30))
+	w.Tick(now.Add(time.Minute))
+	if p := w.User("UALICE").Points; p != 4 {
+		t.Errorf("expected alice to get 2 salaries, has %d", p)
+	}
+	if p := w.User("UBOB").Points; p != 5 {
+		t.Errorf("expected bob to reach the daily cap, has %d", p)
+	}
+	if p := w.User("UCAROL").Points; p != 0 {
+		t.Errorf("expected carol to get nothing while away, has %d", p)
+	}
+	if r := w.Say("UALICE", "salary bob"); r != "bob earned 5 points today. salary: 2 per active 1m0s, at most 5 a day" {
+		t.Errorf("unexpected reply %q", r)
+	}
+
+	adi.Lock()
+	sal.Mode = adi.SalaryActivity
+	sal.Rate = 1
+	sal.DailyCap = 0
+	sal.ExcludedChannels = []string{"CSPAM"}
+	adi.Unlock()
+	w.Send("UCAROL", aditest.Channel, "hi")
+	w.Send("UCAROL", aditest.Channel, "there")
+	w.Send("UCAROL", "CSPAM", "spam")
+	w.Tick(now.Add(time.Hour * 24))
+	if p := w.User("UCAROL").Points; p != 2 {
+		t.Errorf("expected carol to get paid for 2 messages, has %d", p)
+	}
+	if p := w.User("UALICE").Points; p != 5 {
+		t.Errorf("expected alice to get paid for 1 message, has %d", p)
+	}
+	if r := w.Say("UCAROL", "chkledger"); r != "all balances match the ledger" {
+		t.Errorf("unexpected reply %q", r)
+	}
+}
+
+func TestSalaryZeroMultiplier(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour * 24).Add(time.Hour * 12)
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 0)
+	w.AddUser("UBOB", "bob", 5, 0)
+	adi.Lock()
+	adi.Deposit(adi.BankAccount(), 100, "test")
+	sal := &adi.GlobalBank.Salary
+	sal.Rate = 2
+	sal.Every = time.Minute
+	sal.LevelMultipliers = map[adi.Level]float64{0: 0, 5: 1}
+	adi.Unlock()
+	w.Tick(now)
+	if p := w.User("UALICE").Points; p != 0 {
+		t.Errorf("expected alice to get nothing, has %d", p)
+	}
+	if p := w.User("UBOB").Points; p != 2 {
+		t.Errorf("expected bob to get paid after alice, has %d", p)
+	}
+}
@@ -2,46 +2,102 @@ package proxycommands
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/henkman/slackbot/adi"
-	"github.com/nlopes/slack"
 )
 
 func init() {
 
-	adi.RegisterFunc("setproxy",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if m.Text == "" {
+	adi.RegisterCommand("setproxy",
+		adi.Spec{
+			Description: "sets a proxy command running a template in which " +
+				"$1, $2, $@, ${1:-default}, $user and $channel are replaced",
+			Examples: []string{
+				"setproxy mine pts $user",
+				"setproxy tip givepts $1 ${2:-5}",
+			},
+			Args: []adi.Arg{
+				{Name: "name", Type: adi.ArgWord},
+				{Name: "command", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			if !adi.LockChange(m) {
+				return adi.Response{}
+			}
+			defer adi.Unlock()
+			n := m.Args.String("name")
+			if !adi.ValidCommandName(n) {
 				return adi.Response{
-					Text: "sets a proxy command",
+					Text: fmt.Sprintf("%q is not a valid name. "+
+						"use only letters, digits, _ and -", n),
 				}
 			}
-			s := strings.Split(m.Text, " ")
-			if len(s) < 2 {
+			t := adi.UrlUnFurl(m.Args.String("command"))
+			if err := adi.CheckTemplate(t); err != nil {
 				return adi.Response{
-					Text: "syntax: setproxy [name] [cmd]",
+					Text: err.Error(),
 				}
 			}
-			n := s[0]
-			t := adi.UrlUnFurl(strings.Join(s[1:], " "))
 			c := adi.GetCommandByName(n)
-			if c == nil {
-				adi.Commands = append(adi.Commands, adi.Command{
-					Name:          n,
-					Proxy:         t,
-					RequiredLevel: adi.DefaultLevel,
-					Price:         0,
-					Visible:       false,
-				})
-				adi.ResetCommands()
-			} else {
-				if c.Func != nil {
+			if c != nil && c.Func != nil {
+				return adi.Response{
+					Text: fmt.Sprintf("%s is not a proxy command", n),
+				}
+			}
+			if c != nil && !adi.MayEdit(m.User, c) {
+				return adi.Response{
+					Text: notYours(c),
+				}
+			}
+			// a proxy must not be a way around the price or level of
+			// what it runs
+			var (
+				price adi.Points
+				level = adi.DefaultLevel
+			)
+			for _, name := range adi.ProxyTargets(t) {
+				target := adi.GetCommandByName(name)
+				if target == nil {
 					return adi.Response{
-						Text: fmt.Sprintf("%s is not a proxy command", s[0]),
+						Text: fmt.Sprintf("unknown command %s", name),
 					}
 				}
-				c.Proxy = t
+				price += target.Price
+				if target.RequiredLevel > level {
+					level = target.RequiredLevel
+				}
+			}
+			if err := adi.CheckProxy(n, t); err != nil {
+				return adi.Response{
+					Text: err.Error(),
+				}
+			}
+			before := ""
+			if c != nil {
+				before = c.Proxy
+			}
+			adi.Audit(m, n, before, t)
+			if c == nil {
+				c = &adi.Command{
+					Name:    n,
+					Creator: m.User.ID,
+					Visible: false,
+				}
+				adi.Commands = append(adi.Commands, c)
+				adi.ResetCommands()
+			}
+			c.Proxy = t
+			if c.Creator == "" {
+				// proxies from before creators were recorded
+				c.Creator = m.User.ID
+			}
+			adi.Changed()
+			if c.Price < price {
+				c.Price = price
+			}
+			if c.RequiredLevel < level {
+				c.RequiredLevel = level
 			}
 			return adi.Response{
 				Text:   fmt.Sprintf("set %s to \"%s\"", n, t),
@@ -49,16 +105,22 @@ func init() {
 			}
 		})
 
-	adi.RegisterFunc("delproxy",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if m.Text == "" {
-				return adi.Response{
-					Text: "deletes a proxy command",
-				}
+	adi.RegisterCommand("delproxy",
+		adi.Spec{
+			Description: "deletes a proxy command",
+			Args: []adi.Arg{
+				{Name: "name", Type: adi.ArgWord},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			if !adi.LockChange(m) {
+				return adi.Response{}
 			}
+			defer adi.Unlock()
+			n := m.Args.String("name")
 			o := -1
 			for i, _ := range adi.Commands {
-				if adi.Commands[i].Proxy != "" && adi.Commands[i].Name == m.Text {
+				if adi.Commands[i].Proxy != "" && adi.Commands[i].Name == n {
 					o = i
 					break
 				}
@@ -68,11 +130,52 @@ func init() {
 					Text: "command does not exist",
 				}
 			}
+			if !adi.MayEdit(m.User, adi.Commands[o]) {
+				return adi.Response{
+					Text: notYours(adi.Commands[o]),
+				}
+			}
+			adi.Audit(m, n, adi.Commands[o].Proxy, "")
 			adi.Commands = append(adi.Commands[:o], adi.Commands[o+1:]...)
 			adi.ResetCommands()
 			return adi.Response{
-				Text:   fmt.Sprintf("%s deleted", m.Text),
+				Text:   fmt.Sprintf("%s deleted", n),
 				Charge: true,
 			}
 		})
+
+	adi.RegisterCommand("showproxy",
+		adi.Spec{
+			Description: "shows what a proxy command runs",
+			Args: []adi.Arg{
+				{Name: "name", Type: adi.ArgCommand},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			adi.RLock()
+			defer adi.RUnlock()
+			n := m.Args.String("name")
+			c := adi.GetCommandByName(n)
+			if c == nil || c.Proxy == "" {
+				return adi.Response{
+					Text: fmt.Sprintf("%s is not a proxy command", n),
+				}
+			}
+			t := fmt.Sprintf("%s runs \"%s\"", n, c.Proxy)
+			if c.Creator != "" {
+				t += " and was set by " + adi.NameOf(c.Creator)
+			}
+			return adi.Response{
+				Text:   t,
+				Charge: true,
+			}
+		})
+}
+
+func notYours(c *adi.Command) string {
+	if c.Creator == "" {
+		return fmt.Sprintf("only admins can change %s", c.Name)
+	}
+	return fmt.Sprintf("only %s and admins can change %s",
+		adi.NameOf(c.Creator), c.Name)
 }
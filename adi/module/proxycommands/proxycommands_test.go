@@ -0,0 +1,109 @@
+package proxycommands
+
+import (
+	"testing"
+
+	"github.com/henkman/slackbot/adi"
+	"github.com/henkman/slackbot/adi/aditest"
+	_ "github.com/henkman/slackbot/adi/module/points"
+)
+
+func TestProxy(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 10)
+	w.AddUser("UBOB", "bob", 0, 20)
+	tests := []struct {
+		text  string
+		reply string
+	}{
+		{"setproxy", "sets a proxy command running a template in which " +
+			"$1, $2, $@, ${1:-default}, $user and $channel are replaced\n" +
+			"syntax: setproxy <name> <command...>"},
+		{"setproxy mine", "syntax: setproxy <name> <command...>"},
+		{"setproxy pts x", "pts is not a proxy command"},
+		{"setproxy my.pts pts", `"my.pts" is not a valid name. use only letters, digits, _ and -`},
+		{`setproxy "my pts" pts`, `"my pts" is not a valid name. use only letters, digits, _ and -`},
+		{"setproxy (pts pts", `"(pts" is not a valid name. use only letters, digits, _ and -`},
+		{"setproxy mine pts", `set mine to "pts"`},
+		{"mine", "your points: 10"},
+		{"setproxy of pts %s", `set of to "pts %s"`},
+		{"of bob", "bob points: 20"},
+		{"setproxy of pts $1", `set of to "pts $1"`},
+		{"of bob", "bob points: 20"},
+		{"of", "of needs argument $1"},
+		{"setproxy of pts ${1:-bob}", `set of to "pts ${1:-bob}"`},
+		{"of", "bob points: 20"},
+		{"of alice", "alice points: 10"},
+		{"setproxy me pts $user", `set me to "pts $user"`},
+		{"me", "alice points: 10"},
+		{"setproxy give givepts $2 $1", `set give to "givepts $2 $1"`},
+		{"give 1 bob", "bob points 21. your points: 9"},
+		{"setproxy bad pts $who", "unknown placeholder $who"},
+		{"setproxy bad pts ${1:-x", "${ is not closed"},
+		{"setproxy bad $1 x", "a proxy has to start with a command"},
+		{"setproxy bad pts$1", "a proxy has to start with a command"},
+		{"showproxy give", `give runs "givepts $2 $1" and was set by alice`},
+		{"setproxy bad nope", "unknown command nope"},
+		{"setproxy loop mine", `set loop to "mine"`},
+		{"setproxy mine loop", "proxy mine is a cycle: mine -> loop -> mine"},
+		{"setproxy both mine; of bob", `set both to "mine; of bob"`},
+		{"both", "your points: 9\nbob points: 21"},
+		{"showproxy pts", "pts is not a proxy command"},
+		{"showproxy nope", "command not found"},
+		{"delproxy of", "of deleted"},
+		{"delproxy of", "command does not exist"},
+		{"delproxy pts", "command does not exist"},
+	}
+	for _, test := range tests {
+		if r := w.Say("UALICE", test.text); r != test.reply {
+			t.Errorf("%q: expected reply %q, got %q", test.text, test.reply, r)
+		}
+	}
+}
+
+func TestProxyOwner(t *testing.T) {
+	w := aditest.New()
+	w.AddUser("UALICE", "alice", 0, 10)
+	w.AddUser("UBOB", "bob", 0, 20)
+	w.AddUser("UADMIN", "admin", adi.MaxLevel, 0)
+	w.AddUser("UCAROL", "carol", 0, 0)
+	adi.Roles = map[string][]string{"mod": {adi.ActionManageProxies}}
+	adi.Lock()
+	adi.GetCreateUser("UCAROL").Roles = []string{"mod"}
+	adi.Unlock()
+	adi.GetCommandByName("givepts").Price = 2
+	adi.GetCommandByName("trpts").RequiredLevel = adi.MaxLevel
+	tests := []struct {
+		user  string
+		text  string
+		reply string
+	}{
+		{"UALICE", "setproxy tip givepts $1 1", `set tip to "givepts $1 1"`},
+		{"UALICE", "setproxy steal trpts bob alice 5", `set steal to "trpts bob alice 5"`},
+		{"UALICE", "steal", "unprivileged. your level: 0. required: 255"},
+		{"UBOB", "setproxy tip pts", "only alice and admins can change tip"},
+		{"UBOB", "delproxy tip", "only alice and admins can change tip"},
+		{"UADMIN", "setproxy tip givepts $1 2", `set tip to "givepts $1 2"`},
+		{"UCAROL", "setproxy tip givepts $1 3", `set tip to "givepts $1 3"`},
+		{"UALICE", "delproxy tip", "tip deleted"},
+	}
+	for _, test := range tests {
+		if r := w.Say(test.user, test.text); r != test.reply {
+			t.Errorf("%s %q: expected reply %q, got %q",
+				test.user, test.text, test.reply, r)
+		}
+		if test.text != "setproxy tip givepts $1 1" {
+			continue
+		}
+		tip := adi.GetCommandByName("tip")
+		if tip.Price != 2 || tip.Creator != "UALICE" {
+			t.Errorf("expected tip to cost 2 and belong to alice, got %d %s",
+				tip.Price, tip.Creator)
+		}
+	}
+	// the command a proxy runs is checked even if the proxy was changed
+	adi.GetCommandByName("steal").RequiredLevel = 0
+	if r := w.Say("UALICE", "steal"); r != "unprivileged. your level: 0. required: 255" {
+		t.Errorf("expected trpts to be checked, got %q", r)
+	}
+}
@@ -1,89 +1,92 @@
 package duckduckgo
 
 import (
-	"fmt"
 	"log"
 
 	"github.com/henkman/duckduckgo"
 	"github.com/henkman/slackbot/adi"
-	"github.com/nlopes/slack"
+	"github.com/henkman/slackbot/adi/module/web"
 )
 
+// imageResults is the number of search results random images are
+// picked from.
+const imageResults = 1000
+
 var (
 	sess duckduckgo.Session
 )
 
 func init() {
 
-	adi.RegisterFunc("ddgimg",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			const N = 1000
-			if m.Text == "" {
-				return adi.Response{
-					Text: fmt.Sprintf(
-						"gets random image from first %d search results", N),
-				}
-			}
-			return duckduckgoImage(m.Text, true,
-				duckduckgo.ImageType_Any, uint(adi.RandUint32(N)))
+	adi.RegisterCommand("ddgimg",
+		adi.Spec{
+			Description: "show a random image of the first 1000 search results",
+			Examples:    []string{"ddgimg cat"},
+			Args: []adi.Arg{
+				{Name: "query", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return duckduckgoImage(m.Args.String("query"), true,
+				duckduckgo.ImageType_Any, uint(adi.RandUint32(imageResults)))
 		})
 
-	adi.RegisterFunc("ddgimgnsfw",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			const N = 1000
-			if m.Text == "" {
-				return adi.Response{
-					Text: fmt.Sprintf(
-						"gets random image from first %d search results", N),
-				}
-			}
-			return duckduckgoImage(m.Text, false,
-				duckduckgo.ImageType_Any, uint(adi.RandUint32(N)))
+	adi.RegisterCommand("ddgimgnsfw",
+		adi.Spec{
+			Description: "show a random image of the first 1000 search results without safe search",
+			Examples:    []string{"ddgimgnsfw cat"},
+			Args: []adi.Arg{
+				{Name: "query", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return duckduckgoImage(m.Args.String("query"), false,
+				duckduckgo.ImageType_Any, uint(adi.RandUint32(imageResults)))
 		})
 
-	adi.RegisterFunc("ddggif",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			const N = 1000
-			if m.Text == "" {
-				return adi.Response{
-					Text: fmt.Sprintf(
-						"gets random image from first %d search results", N),
-				}
-			}
-			return duckduckgoImage(m.Text, true,
-				duckduckgo.ImageType_Animated, uint(adi.RandUint32(N)))
+	adi.RegisterCommand("ddggif",
+		adi.Spec{
+			Description: "show a random animated image of the first 1000 search results",
+			Examples:    []string{"ddggif dancing cat"},
+			Args: []adi.Arg{
+				{Name: "query", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return duckduckgoImage(m.Args.String("query"), true,
+				duckduckgo.ImageType_Animated, uint(adi.RandUint32(imageResults)))
 		})
 
-	adi.RegisterFunc("ddggifnsfw",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			const N = 1000
-			if m.Text == "" {
-				return adi.Response{
-					Text: fmt.Sprintf(
-						"gets random image from first %d search results", N),
-				}
-			}
-			return duckduckgoImage(m.Text, false,
-				duckduckgo.ImageType_Animated, uint(adi.RandUint32(N)))
+	adi.RegisterCommand("ddggifnsfw",
+		adi.Spec{
+			Description: "show a random animated image of the first 1000 search results without safe search",
+			Examples:    []string{"ddggifnsfw dancing cat"},
+			Args: []adi.Arg{
+				{Name: "query", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return duckduckgoImage(m.Args.String("query"), false,
+				duckduckgo.ImageType_Animated, uint(adi.RandUint32(imageResults)))
 		})
 
-	adi.RegisterFunc("ddgvid",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if m.Text == "" {
+	adi.RegisterCommand("ddgvid",
+		adi.Spec{
+			Description: "show a random video found for the query",
+			Examples:    []string{"ddgvid cat piano"},
+			Args: []adi.Arg{
+				{Name: "query", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			if err := web.InitSession(&sess); err != nil {
+				log.Println("ERROR:", err)
 				return adi.Response{
-					Text: "finds videos",
-				}
-			}
-			if !sess.IsInitialized() {
-				if err := sess.Init(); err != nil {
-					log.Println("ERROR:", err)
-					return adi.Response{
-						Text: "internal error",
-					}
+					Text: "internal error",
 				}
 			}
-			m.Text = adi.UrlUnFurl(m.Text)
-			vids, err := sess.Videos(m.Text, 0)
+			q := adi.UrlUnFurl(m.Args.String("query"))
+			vids, err := sess.Videos(q, 0)
 			if err != nil {
 				log.Println("ERROR:", err)
 				return adi.Response{
@@ -106,12 +109,10 @@ func init() {
 
 func duckduckgoImage(query string, safe bool,
 	typ duckduckgo.ImageType, offset uint) adi.Response {
-	if !sess.IsInitialized() {
-		if err := sess.Init(); err != nil {
-			log.Println("ERROR:", err)
-			return adi.Response{
-				Text: "internal error",
-			}
+	if err := web.InitSession(&sess); err != nil {
+		log.Println("ERROR:", err)
+		return adi.Response{
+			Text: "internal error",
 		}
 	}
 	query = adi.UrlUnFurl(query)
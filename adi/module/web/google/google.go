@@ -1,6 +1,8 @@
 package google
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
@@ -8,51 +10,123 @@ import (
 
 	"github.com/henkman/google"
 	"github.com/henkman/slackbot/adi"
-	"github.com/nlopes/slack"
+	"github.com/henkman/slackbot/adi/module/web"
 )
 
-const (
-	TLD = "de"
-)
+// DefaultTLD is the google domain searched if config.json does not set
+// modules.google.tld.
+const DefaultTLD = "de"
 
 var (
 	gSess google.Session
+	// tld is guarded by the state of adi.
+	tld = DefaultTLD
 )
 
 func init() {
 
-	adi.RegisterFunc("gl",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			return googleSearch(m.Text, true)
+	adi.RegisterSettings("google",
+		func(raw json.RawMessage) (func(), error) {
+			settings := struct {
+				TLD string `json:"tld"`
+			}{
+				TLD: DefaultTLD,
+			}
+			if raw != nil {
+				if err := json.Unmarshal(raw, &settings); err != nil {
+					return nil, err
+				}
+			}
+			if settings.TLD == "" {
+				return nil, errors.New("tld must not be empty")
+			}
+			return func() {
+				tld = settings.TLD
+			}, nil
+		})
+
+	adi.RegisterCommand("gl",
+		adi.Spec{
+			Description: "search the internet",
+			Examples:    []string{"gl golang generics"},
+			Args: []adi.Arg{
+				{Name: "query", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return googleSearch(m.Args.String("query"), true)
 		})
 
-	adi.RegisterFunc("glnsfw",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			return googleSearch(m.Text, false)
+	adi.RegisterCommand("glnsfw",
+		adi.Spec{
+			Description: "search the internet without safe search",
+			Examples:    []string{"glnsfw golang generics"},
+			Args: []adi.Arg{
+				{Name: "query", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return googleSearch(m.Args.String("query"), false)
 		})
 
-	adi.RegisterFunc("glimg",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			return googleImage(m.Text, true, google.ImageType_Any)
+	adi.RegisterCommand("glimg",
+		adi.Spec{
+			Description: "show a random image found for the query",
+			Examples:    []string{"glimg cat"},
+			Args: []adi.Arg{
+				{Name: "query", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return googleImage(m.Args.String("query"), true, google.ImageType_Any)
 		})
 
-	adi.RegisterFunc("glimgnsfw",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			return googleImage(m.Text, false, google.ImageType_Any)
+	adi.RegisterCommand("glimgnsfw",
+		adi.Spec{
+			Description: "show a random image found for the query without safe search",
+			Examples:    []string{"glimgnsfw cat"},
+			Args: []adi.Arg{
+				{Name: "query", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return googleImage(m.Args.String("query"), false, google.ImageType_Any)
 		})
 
-	adi.RegisterFunc("glgif",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			return googleImage(m.Text, true, google.ImageType_Animated)
+	adi.RegisterCommand("glgif",
+		adi.Spec{
+			Description: "show a random animated image found for the query",
+			Examples:    []string{"glgif dancing cat"},
+			Args: []adi.Arg{
+				{Name: "query", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return googleImage(m.Args.String("query"), true, google.ImageType_Animated)
 		})
 
-	adi.RegisterFunc("glgifnsfw",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			return googleImage(m.Text, false, google.ImageType_Animated)
+	adi.RegisterCommand("glgifnsfw",
+		adi.Spec{
+			Description: "show a random animated image found for the query without safe search",
+			Examples:    []string{"glgifnsfw dancing cat"},
+			Args: []adi.Arg{
+				{Name: "query", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return googleImage(m.Args.String("query"), false, google.ImageType_Animated)
 		})
 
-	adi.RegisterFunc("tr",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
+	adi.RegisterCommand("tr",
+		adi.Spec{
+			Description: "translate text to the language",
+			Examples:    []string{"tr fr good morning", "tr ja thank you"},
+			Args: []adi.Arg{
+				{Name: "language", Type: adi.ArgWord},
+				{Name: "text", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
 			languages := []string{
 				"af", "ar", "az", "be", "bg", "ca", "cs", "cy", "da", "de",
 				"el", "en", "es", "et", "eu", "fa", "fi", "fr", "ga", "gl",
@@ -61,20 +135,7 @@ func init() {
 				"pt", "ro", "ru", "sk", "sl", "sq", "sr", "sv", "sw", "th",
 				"tl", "tr", "uk", "ur", "vi", "yi",
 			}
-			help := "translates text. available languages:\n" +
-				strings.Join(languages, ", ")
-			if m.Text == "" {
-				return adi.Response{
-					Text: help,
-				}
-			}
-			s := strings.Index(m.Text, " ")
-			if s == -1 {
-				return adi.Response{
-					Text: help,
-				}
-			}
-			l := m.Text[:s]
+			l := m.Args.String("language")
 			{
 				ok := false
 				for _, e := range languages {
@@ -85,41 +146,51 @@ func init() {
 				}
 				if !ok {
 					return adi.Response{
-						Text: "language not supported",
+						Text: "language not supported. available languages:\n" +
+							strings.Join(languages, ", "),
 					}
 				}
 			}
-			t := m.Text[s:]
-			return googleTranslate(t, l)
+			return googleTranslate(m.Args.String("text"), l)
 		})
 
-	adi.RegisterFunc("en",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			return googleTranslate(m.Text, "en")
+	adi.RegisterCommand("en",
+		adi.Spec{
+			Description: "translate text to english",
+			Examples:    []string{"en guten Morgen"},
+			Args: []adi.Arg{
+				{Name: "text", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return googleTranslate(m.Args.String("text"), "en")
 		})
 
-	adi.RegisterFunc("de",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			return googleTranslate(m.Text, "de")
+	adi.RegisterCommand("de",
+		adi.Spec{
+			Description: "translate text to german",
+			Examples:    []string{"de good morning"},
+			Args: []adi.Arg{
+				{Name: "text", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return googleTranslate(m.Args.String("text"), "de")
 		})
 }
 
 func googleSearch(text string, safe bool) adi.Response {
-	if text == "" {
+	if err := web.InitSession(&gSess); err != nil {
+		log.Println("ERROR:", err.Error())
 		return adi.Response{
-			Text: "finds stuff in the internet",
-		}
-	}
-	if !gSess.IsInitialized() {
-		if err := gSess.Init(); err != nil {
-			log.Println("ERROR:", err.Error())
-			return adi.Response{
-				Text: "internal error",
-			}
+			Text: "internal error",
 		}
 	}
 	text = adi.UrlUnFurl(text)
-	results, err := gSess.Search(TLD, text, "en", safe, 0, 5)
+	adi.RLock()
+	t := tld
+	adi.RUnlock()
+	results, err := gSess.Search(t, text, "en", safe, 0, 5)
 	if err != nil {
 		log.Println("ERROR:", err.Error())
 		return adi.Response{
@@ -142,21 +213,17 @@ func googleSearch(text string, safe bool) adi.Response {
 }
 
 func googleImage(text string, safe bool, typ google.ImageType) adi.Response {
-	if text == "" {
+	if err := web.InitSession(&gSess); err != nil {
+		log.Println("ERROR:", err.Error())
 		return adi.Response{
-			Text: "finds images",
-		}
-	}
-	if !gSess.IsInitialized() {
-		if err := gSess.Init(); err != nil {
-			log.Println("ERROR:", err.Error())
-			return adi.Response{
-				Text: "internal error",
-			}
+			Text: "internal error",
 		}
 	}
 	text = adi.UrlUnFurl(text)
-	images, err := gSess.Images(TLD, text, "de", safe, typ, 0, 50)
+	adi.RLock()
+	t := tld
+	adi.RUnlock()
+	images, err := gSess.Images(t, text, "de", safe, typ, 0, 50)
 	if err != nil {
 		log.Println("ERROR:", err)
 		return adi.Response{
@@ -184,17 +251,10 @@ func googleImage(text string, safe bool, typ google.ImageType) adi.Response {
 }
 
 func googleTranslate(text, tl string) adi.Response {
-	if text == "" {
+	if err := web.InitSession(&gSess); err != nil {
+		log.Println("ERROR:", err.Error())
 		return adi.Response{
-			Text: "finds images",
-		}
-	}
-	if !gSess.IsInitialized() {
-		if err := gSess.Init(); err != nil {
-			log.Println("ERROR:", err.Error())
-			return adi.Response{
-				Text: "internal error",
-			}
+			Text: "internal error",
 		}
 	}
 	lt, err := gSess.Translate(text, "auto", tl)
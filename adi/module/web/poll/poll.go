@@ -2,37 +2,43 @@ package poll
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"strings"
-	"time"
 
 	"github.com/henkman/slackbot/adi"
-	"github.com/nlopes/slack"
 )
 
 func init() {
 
-	adi.RegisterFunc("pollmul",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			return poll(m.Text, true)
+	adi.RegisterCommand("pollmul",
+		adi.Spec{
+			Description: "create a poll with the question and comma separated choices, of which voters may pick several",
+			Examples:    []string{"pollmul pets?, dog, cat, hamster"},
+			Args: []adi.Arg{
+				{Name: "poll", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return poll(m.Context, m.Args.String("poll"), true)
 		})
 
-	adi.RegisterFunc("poll",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			return poll(m.Text, false)
+	adi.RegisterCommand("poll",
+		adi.Spec{
+			Description: "create a poll with the question and comma separated choices",
+			Examples:    []string{"poll animal?, dog, cat, hamster"},
+			Args: []adi.Arg{
+				{Name: "poll", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			return poll(m.Context, m.Args.String("poll"), false)
 		})
 }
 
-func poll(text string, multi bool) adi.Response {
-	if text == "" {
-		return adi.Response{
-			Text: `creates a poll
-Example: poll animal?, dog, cat, hamster
--> creates a poll with title animal? and the three animals as choices`,
-		}
-	}
+func poll(ctx context.Context, text string, multi bool) adi.Response {
 	s := strings.Split(text, ",")
 	if len(s) < 3 {
 		return adi.Response{
@@ -57,9 +63,9 @@ Example: poll animal?, dog, cat, hamster
 			Text: "internal error",
 		}
 	}
-	res, err := adi.HttpPostWithTimeout(
+	res, err := adi.HttpPostWithContext(ctx,
 		"https://www.strawpoll.me/api/v2/polls",
-		"application/json", bytes.NewBuffer(data), time.Second*10)
+		"application/json", bytes.NewBuffer(data))
 	if err != nil {
 		log.Println("ERROR:", err)
 		return adi.Response{
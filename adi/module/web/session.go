@@ -0,0 +1,26 @@
+package web
+
+import (
+	"sync"
+)
+
+// Session is a session with a web service that has to be initialized
+// before it is used.
+type Session interface {
+	IsInitialized() bool
+	Init() error
+}
+
+var sessionMu sync.Mutex
+
+// InitSession initializes s on first use. Commands run at the same
+// time, so sessions are initialized one at a time, and one that failed
+// is tried again the next time.
+func InitSession(s Session) error {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	if s.IsInitialized() {
+		return nil
+	}
+	return s.Init()
+}
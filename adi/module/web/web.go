@@ -9,28 +9,26 @@ import (
 	"net/url"
 	"sort"
 	"strings"
-	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/henkman/slackbot/adi"
-	"github.com/nlopes/slack"
 )
 
 func init() {
 
-	adi.RegisterFunc("synonym",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			q := strings.TrimSpace(m.Text)
-			if q == "" {
-				return adi.Response{
-					Text: "finds synonyms",
-				}
-			}
-			q = strings.ToLower(q)
-			res, err := adi.HttpGetWithTimeout(fmt.Sprintf(
+	adi.RegisterCommand("synonym",
+		adi.Spec{
+			Description: "find german synonyms of the word",
+			Examples:    []string{"synonym schnell"},
+			Args: []adi.Arg{
+				{Name: "word", Type: adi.ArgText},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			q := strings.ToLower(m.Args.String("word"))
+			res, err := adi.HttpGetWithContext(m.Context, fmt.Sprintf(
 				"https://www.openthesaurus.de/synonyme/search?q=%s&format=application/json",
-				url.QueryEscape(q)),
-				time.Second*10)
+				url.QueryEscape(q)))
 			if err != nil {
 				log.Println("ERROR:", err)
 				return adi.Response{
@@ -74,16 +72,21 @@ func init() {
 			}
 		})
 
-	adi.RegisterFunc("song",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
+	adi.RegisterCommand("song",
+		adi.Spec{
+			Description: "show the song playing in the dubtrack room",
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			adi.RLock()
+			room := adi.DubtrackRoom
+			adi.RUnlock()
 			var res *http.Response
 			{
 				var err error
-				res, err = adi.HttpGetWithTimeout(
+				res, err = adi.HttpGetWithContext(m.Context,
 					fmt.Sprintf(
 						"https://api.dubtrack.fm/room/%s",
-						adi.DubtrackRoom),
-					time.Second*10)
+						room))
 				if err != nil {
 					log.Println("ERROR:", err)
 					return adi.Response{
@@ -91,7 +94,7 @@ func init() {
 					}
 				}
 			}
-			var room struct {
+			var info struct {
 				Data struct {
 					ActiveUsers int `json:"activeUsers"`
 					CurrentSong *struct {
@@ -99,7 +102,7 @@ func init() {
 					} `json:"currentSong"`
 				} `json:"data"`
 			}
-			if err := json.NewDecoder(res.Body).Decode(&room); err != nil {
+			if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
 				res.Body.Close()
 				log.Println("ERROR:", err)
 				return adi.Response{
@@ -108,7 +111,7 @@ func init() {
 			}
 			res.Body.Close()
 			var t string
-			d := room.Data
+			d := info.Data
 			if d.CurrentSong == nil {
 				t = fmt.Sprintf("Currently playing nothing. %d are listening",
 					d.ActiveUsers)
@@ -123,11 +126,13 @@ func init() {
 			}
 		})
 
-	adi.RegisterFunc("fact",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			res, err := adi.HttpGetWithTimeout(
-				"http://randomfunfacts.com/",
-				time.Second*10)
+	adi.RegisterCommand("fact",
+		adi.Spec{
+			Description: "tell a random fact",
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			res, err := adi.HttpGetWithContext(m.Context,
+				"http://randomfunfacts.com/")
 			if err != nil {
 				log.Println("ERROR:", err)
 				return adi.Response{
@@ -148,11 +153,13 @@ func init() {
 			}
 		})
 
-	adi.RegisterFunc("toon",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			res, err := adi.HttpGetWithTimeout(
-				"http://www.veryfunnycartoons.com/",
-				time.Second*10)
+	adi.RegisterCommand("toon",
+		adi.Spec{
+			Description: "show a random cartoon",
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			res, err := adi.HttpGetWithContext(m.Context,
+				"http://www.veryfunnycartoons.com/")
 			if err != nil {
 				log.Println("ERROR:", err)
 				return adi.Response{
@@ -180,10 +187,13 @@ func init() {
 			}
 		})
 
-	adi.RegisterFunc("insult",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			res, err := adi.HttpGetWithTimeout("http://www.randominsults.net/",
-				time.Second*10)
+	adi.RegisterCommand("insult",
+		adi.Spec{
+			Description: "show a random insult",
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			res, err := adi.HttpGetWithContext(m.Context,
+				"http://www.randominsults.net/")
 			if err != nil {
 				log.Println("ERROR:", err)
 				return adi.Response{
@@ -7,8 +7,8 @@ import (
 
 	"github.com/henkman/ipinfo"
 	"github.com/henkman/slackbot/adi"
+	"github.com/henkman/slackbot/adi/module/web"
 	"github.com/henkman/yahoo"
-	"github.com/nlopes/slack"
 )
 
 var (
@@ -26,18 +26,23 @@ func formatWeather(wf yahoo.WeatherForecast) string {
 
 func init() {
 
-	adi.RegisterFunc("weather",
-		func(m adi.Message, rtm *slack.RTM) adi.Response {
-			if !session.IsInitialized() {
-				if err := session.Init(); err != nil {
-					log.Println("yahoo api:", err.Error())
-					return adi.Response{
-						Text: "could not initialize weather api",
-					}
+	adi.RegisterCommand("weather",
+		adi.Spec{
+			Description: "show the weather forecast for the location or where adi runs",
+			Examples:    []string{"weather", "weather Berlin, Germany"},
+			Args: []adi.Arg{
+				{Name: "location", Type: adi.ArgText, Optional: true},
+			},
+		},
+		func(m adi.Message, tr adi.Transport) adi.Response {
+			if err := web.InitSession(&session); err != nil {
+				log.Println("yahoo api:", err.Error())
+				return adi.Response{
+					Text: "could not initialize weather api",
 				}
 			}
 			var location string
-			text := strings.TrimSpace(m.Text)
+			text := m.Args.String("location")
 			if text == "" {
 				info, err := ipinfo.Query("")
 				if err != nil {
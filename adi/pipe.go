@@ -0,0 +1,53 @@
+package adi
+
+import (
+	"strings"
+)
+
+// maxStages is how many commands a pipeline may have.
+const maxStages = 5
+
+// splitPipeline splits text like "rnd a,b,c | glimg" into the commands
+// of a pipeline. Only a single | outside of quotes and slack links that
+// is followed by a command separates them, so "calc 1 | 2" or "js a ||
+// b" are left alone. The state has to be locked.
+func splitPipeline(text string) []string {
+	var stages []string
+	start := 0
+	quote := false
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '"':
+			quote = !quote
+		case '<':
+			if quote {
+				continue
+			}
+			if end := strings.IndexByte(text[i:], '>'); end != -1 {
+				i += end
+			}
+		case '|':
+			if quote ||
+				(i > 0 && text[i-1] == '|') ||
+				(i+1 < len(text) && text[i+1] == '|') {
+				continue
+			}
+			if _, _, err := parseCommand(strings.TrimSpace(text[i+1:])); err != nil {
+				continue
+			}
+			stages = append(stages, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	return append(stages, strings.TrimSpace(text[start:]))
+}
+
+// feed appends the output of the previous command of a pipeline to
+// the text of the next one.
+func feed(text, output string) string {
+	output = strings.TrimSpace(output)
+	if output == "" {
+		return text
+	}
+	return text + " " + output
+}
@@ -0,0 +1,237 @@
+package adi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// SettingsFunc checks the settings of a module from config.json and
+// returns a func that makes the module use them. The returned func is
+// called with the state locked.
+type SettingsFunc func(raw json.RawMessage) (apply func(), err error)
+
+var (
+	moduleSettings = map[string]SettingsFunc{}
+	reCommandName  = regexp.MustCompile(`^[\pL\pN_-]+$`)
+)
+
+// RegisterSettings makes f get the settings of module, which are kept
+// in the "modules" object of config.json, at start and on every reload.
+func RegisterSettings(module string, f SettingsFunc) {
+	moduleSettings[module] = f
+}
+
+type config struct {
+	Debug            bool   `json:"debug"`
+	Key              string `json:"key"`
+	ShortCommands    bool   `json:"short_commands"`
+	ShortCommandSign string `json:"short_command_sign"`
+	DefaultLevel     Level  `json:"default_level"`
+	DubtrackRoom     string `json:"dubtrack_room"`
+	Workers          int    `json:"workers"`
+	Store            struct {
+		Type    string `json:"type"`
+		Path    string `json:"path"`
+		Backups int    `json:"backups"`
+	} `json:"store"`
+	Ledger     string `json:"ledger"`
+	Audit      string `json:"audit"`
+	RateLimits struct {
+		User    RateLimit `json:"user"`
+		Channel RateLimit `json:"channel"`
+	} `json:"rate_limits"`
+	Owners  []string                   `json:"owners"`
+	Roles   map[string][]string        `json:"roles"`
+	Modules map[string]json.RawMessage `json:"modules"`
+}
+
+func readConfig(path string) (config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config{}, err
+	}
+	return parseConfig(path, data)
+}
+
+func parseConfig(path string, data []byte) (config, error) {
+	var c config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("%s: %s", path, err)
+	}
+	return c, nil
+}
+
+// checkSettings runs the settings func of every module on its part of
+// c and returns the funcs applying them, or the first error.
+func checkSettings(c config) ([]func(), error) {
+	names := make([]string, 0, len(moduleSettings))
+	for name := range moduleSettings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	applies := make([]func(), 0, len(names))
+	for _, name := range names {
+		apply, err := moduleSettings[name](c.Modules[name])
+		if err != nil {
+			return nil, fmt.Errorf("modules.%s: %s", name, err)
+		}
+		applies = append(applies, apply)
+	}
+	return applies, nil
+}
+
+// ValidCommandName reports whether name can be the name of a command.
+func ValidCommandName(name string) bool {
+	return reCommandName.MatchString(name)
+}
+
+// checkCommands returns an error if cmds can not be used as Commands.
+func checkCommands(cmds []*Command) error {
+	seen := map[string]bool{}
+	for i, c := range cmds {
+		if c == nil || !ValidCommandName(c.Name) {
+			return fmt.Errorf("command %d has an invalid name", i+1)
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("command %s is defined twice", c.Name)
+		}
+		seen[c.Name] = true
+	}
+	return nil
+}
+
+// reloader reloads config.json and commands.json when they changed or
+// when asked to.
+type reloader struct {
+	configPath   string
+	commandsPath string
+	// config and commands are what was last read from the files, so
+	// nothing is done if only their modification time changed. Commands
+	// kept in a database are only replaced if the file was edited.
+	config   []byte
+	commands []byte
+	modTimes map[string]time.Time
+}
+
+func newReloader(configPath string, st Store) *reloader {
+	rl := &reloader{
+		configPath:   configPath,
+		commandsPath: "./commands.json",
+		modTimes:     map[string]time.Time{},
+	}
+	if fs, ok := st.(*FileStore); ok {
+		rl.commandsPath = filepath.Join(fs.Dir, "commands.json")
+	}
+	rl.config, _ = ioutil.ReadFile(rl.configPath)
+	rl.commands, _ = ioutil.ReadFile(rl.commandsPath)
+	rl.changed()
+	return rl
+}
+
+// changed reports whether one of the files was modified since the last
+// call.
+func (rl *reloader) changed() bool {
+	changed := false
+	for _, path := range []string{rl.configPath, rl.commandsPath} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !fi.ModTime().Equal(rl.modTimes[path]) {
+			rl.modTimes[path] = fi.ModTime()
+			changed = true
+		}
+	}
+	return changed
+}
+
+// reload applies config.json and commands.json if they changed. Nothing
+// is changed if either of them is invalid.
+func (rl *reloader) reload() error {
+	data, err := ioutil.ReadFile(rl.configPath)
+	if err != nil {
+		return err
+	}
+	c, err := parseConfig(rl.configPath, data)
+	if err != nil {
+		return err
+	}
+	applies, err := checkSettings(c)
+	if err != nil {
+		return err
+	}
+	Lock()
+	defer Unlock()
+	cmds, cmdsData, err := rl.readCommands()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, rl.config) {
+		rl.config = data
+		DefaultLevel = c.DefaultLevel
+		DubtrackRoom = c.DubtrackRoom
+		SetRateLimits(c.RateLimits.User, c.RateLimits.Channel)
+		setRoles(c.Roles)
+		setOwners(c.Owners)
+		if botID != "" {
+			if c.ShortCommands {
+				identify(botID, c.ShortCommandSign)
+			} else {
+				identify(botID, "")
+			}
+		}
+		for _, apply := range applies {
+			apply()
+		}
+		log.Println("reloaded", rl.configPath)
+	}
+	if cmds != nil {
+		rl.commands = cmdsData
+		Commands = cmds
+		ResetCommands()
+		log.Println("reloaded", rl.commandsPath)
+		for _, p := range checkCommandFuncs(cmds) {
+			log.Println("WARNING:", p)
+		}
+		for _, p := range checkOldProxies(cmds) {
+			log.Println("WARNING:", p)
+		}
+	}
+	return nil
+}
+
+// readCommands returns the commands in commandsPath and the content of
+// the file, or nil if they did not change. The state has to be locked,
+// so the store does not write the file meanwhile.
+func (rl *reloader) readCommands() ([]*Command, []byte, error) {
+	data, err := ioutil.ReadFile(rl.commandsPath)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if fs, ok := store.(*FileStore); ok {
+		if bytes.Equal(data, fs.written["commands.json"]) {
+			return nil, nil, nil
+		}
+	} else if bytes.Equal(data, rl.commands) {
+		return nil, nil, nil
+	}
+	var cmds []*Command
+	if err := json.Unmarshal(data, &cmds); err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", rl.commandsPath, err)
+	}
+	if err := checkCommands(cmds); err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", rl.commandsPath, err)
+	}
+	return cmds, data, nil
+}
@@ -0,0 +1,79 @@
+package adi
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, data string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var greeting string
+	RegisterSettings("test", func(raw json.RawMessage) (func(), error) {
+		var settings struct {
+			Greeting string `json:"greeting"`
+		}
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			return nil, err
+		}
+		if settings.Greeting == "" {
+			return nil, errors.New("greeting must not be empty")
+		}
+		return func() { greeting = settings.Greeting }, nil
+	})
+	defer delete(moduleSettings, "test")
+	defer SetStore(nil)
+
+	write("config.json", `{"default_level": 1, "modules": {"test": {"greeting": "hi"}}}`)
+	write("commands.json", `[{"name": "pts", "price": 1}]`)
+	fs := NewFileStore(dir, 0)
+	Users, Commands, GlobalBank = nil, nil, Bank{}
+	if err := fs.Load(&Users, &Commands, &GlobalBank, &Rules); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	SetStore(fs)
+	rl := newReloader(filepath.Join(dir, "config.json"), fs)
+
+	write("config.json", `{"default_level": 2, "modules": {"test": {"greeting": "hello"}}}`)
+	write("commands.json", `[{"name": "pts", "price": 3}, {"name": "rank"}]`)
+	if err := rl.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if DefaultLevel != 2 || greeting != "hello" {
+		t.Errorf("config not applied: level %d, greeting %q", DefaultLevel, greeting)
+	}
+	if len(Commands) != 2 || GetCommandByName("pts").Price != 3 {
+		t.Errorf("commands not applied: %v", Commands)
+	}
+
+	bad := []struct {
+		config, commands string
+	}{
+		{`{"default_level": 3, "modules": {"test": {}}}`, `[{"name": "pts"}]`},
+		{`{"default_level": 3`, `[{"name": "pts"}]`},
+		{`{"default_level": 3, "modules": {"test": {"greeting": "yo"}}}`, `[{"name": "pts"}, {"name": "pts"}]`},
+		{`{"default_level": 3, "modules": {"test": {"greeting": "yo"}}}`, `[{"name": "p s"}]`},
+	}
+	for _, test := range bad {
+		write("config.json", test.config)
+		write("commands.json", test.commands)
+		if err := rl.reload(); err == nil {
+			t.Errorf("%s %s: expected an error", test.config, test.commands)
+		}
+		if DefaultLevel != 2 || greeting != "hello" || len(Commands) != 2 {
+			t.Errorf("%s %s: applied invalid files", test.config, test.commands)
+		}
+	}
+}
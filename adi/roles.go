@@ -0,0 +1,128 @@
+package adi
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Actions are permissions for things several commands do. Commands
+// whose Spec has an Action can be run by users with a role granting it.
+const (
+	// ActionManageProxies lets users change and delete proxies they did
+	// not set.
+	ActionManageProxies = "manage proxies"
+	// ActionMovePoints lets users move points of others.
+	ActionMovePoints = "move points"
+)
+
+// Roles maps the name of each role to its permissions, which are
+// names of commands or actions. Roles are set in config.json and given
+// to users with commands. A user may run a command if one of their
+// roles permits it or, as before, if their level is high enough.
+var Roles = map[string][]string{}
+
+// Owners are the ids of the users set in config.json who always have
+// the highest level, so adi can not be left without admins.
+var Owners []string
+
+// IsOwner reports whether the user with id is an owner.
+func IsOwner(id string) bool {
+	for _, o := range Owners {
+		if o == id {
+			return true
+		}
+	}
+	return false
+}
+
+// setOwners replaces Owners and gives them the highest level. The
+// state has to be locked.
+func setOwners(ids []string) {
+	Owners = ids
+	for _, id := range ids {
+		GetCreateUser(id).Level = MaxLevel
+	}
+	Changed()
+}
+
+// setRoles replaces Roles. The state has to be locked.
+func setRoles(roles map[string][]string) {
+	if roles == nil {
+		roles = map[string][]string{}
+	}
+	Roles = roles
+}
+
+// Can reports whether a role of u grants permission. Admins can do
+// everything. The state has to be locked.
+func Can(u *User, permission string) bool {
+	if IsAdmin(u) {
+		return true
+	}
+	for _, r := range u.Roles {
+		for _, p := range Roles[r] {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Allowed reports whether u may run cmd, because a role permits the
+// command or its action or because the level of u is high enough. The
+// state has to be locked.
+func Allowed(u *User, cmd *Command) bool {
+	if u.Level >= cmd.RequiredLevel || Can(u, cmd.Name) {
+		return true
+	}
+	return cmd.Spec != nil && cmd.Spec.Action != "" && Can(u, cmd.Spec.Action)
+}
+
+// HasRole reports whether u has the role name.
+func HasRole(u *User, name string) bool {
+	for _, r := range u.Roles {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// knownActions returns the actions of all specs and the ones adi
+// checks itself.
+func knownActions() map[string]bool {
+	actions := map[string]bool{
+		ActionManageProxies: true,
+		ActionMovePoints:    true,
+	}
+	for _, s := range commandSpecs {
+		if s != nil && s.Action != "" {
+			actions[s.Action] = true
+		}
+	}
+	return actions
+}
+
+// checkRoles returns the permissions of roles that are neither
+// commands nor actions.
+func checkRoles(roles map[string][]string, cmds []*Command) []string {
+	known := knownActions()
+	for _, c := range cmds {
+		known[c.Name] = true
+	}
+	for _, name := range RegisteredFuncs() {
+		known[name] = true
+	}
+	var problems []string
+	for role, perms := range roles {
+		for _, p := range perms {
+			if !known[p] {
+				problems = append(problems, fmt.Sprintf(
+					"role %s grants unknown permission %q", role, p))
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
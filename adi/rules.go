@@ -0,0 +1,93 @@
+package adi
+
+import (
+	"sort"
+)
+
+// AllChannels is the channel of rules that apply everywhere.
+const AllChannels = "*"
+
+// Rule enables or disables a module or a single command in a channel.
+// Exactly one of Module and Command is set.
+type Rule struct {
+	Channel string `json:"channel"`
+	Module  string `json:"module,omitempty"`
+	Command string `json:"command,omitempty"`
+	Enabled bool   `json:"enabled"`
+}
+
+// Rules are kept in the store. Commands are enabled unless a rule
+// disables them.
+var Rules []Rule
+
+func (r *Rule) sameTarget(o Rule) bool {
+	return r.Channel == o.Channel && r.Module == o.Module &&
+		r.Command == o.Command
+}
+
+// Enabled reports whether cmd may run in channel. A rule for the
+// command wins over a rule for its module, and a rule for the channel
+// over one for all channels. The state has to be locked.
+func Enabled(cmd *Command, channel string) bool {
+	best, enabled := 0, true
+	for _, r := range Rules {
+		prio := 0
+		switch {
+		case r.Command != "" && r.Command == cmd.Name:
+			prio = 3
+		case r.Module != "" && r.Module == cmd.Module:
+			prio = 1
+		default:
+			continue
+		}
+		if r.Channel == channel {
+			prio++
+		} else if r.Channel != AllChannels {
+			continue
+		}
+		if prio > best {
+			best, enabled = prio, r.Enabled
+		}
+	}
+	return enabled
+}
+
+// SetRule adds r or replaces the rule for the same channel and target.
+// The state has to be locked.
+func SetRule(r Rule) {
+	Changed()
+	for i, _ := range Rules {
+		if Rules[i].sameTarget(r) {
+			Rules[i] = r
+			return
+		}
+	}
+	Rules = append(Rules, r)
+}
+
+// DeleteRule removes the rule for the same channel and target as r
+// and reports whether there was one. The state has to be locked.
+func DeleteRule(r Rule) bool {
+	for i, _ := range Rules {
+		if Rules[i].sameTarget(r) {
+			Rules = append(Rules[:i], Rules[i+1:]...)
+			Changed()
+			return true
+		}
+	}
+	return false
+}
+
+// Modules returns the names of all modules that registered commands.
+func Modules() []string {
+	seen := map[string]bool{}
+	var mods []string
+	for _, m := range commandModules {
+		if !seen[m] {
+			seen[m] = true
+			mods = append(mods, m)
+		}
+	}
+	sort.Strings(mods)
+	return mods
+}
@@ -0,0 +1,35 @@
+package adi
+
+import "testing"
+
+func TestEnabled(t *testing.T) {
+	defer func() { Rules = nil }()
+	Rules = []Rule{
+		{Channel: AllChannels, Module: "web", Enabled: false},
+		{Channel: "CRANDOM", Module: "web", Enabled: true},
+		{Channel: AllChannels, Command: "img", Enabled: true},
+		{Channel: "CGENERAL", Command: "img", Enabled: false},
+	}
+	tests := []struct {
+		command string
+		channel string
+		enabled bool
+	}{
+		{"pts", "CGENERAL", true},
+		{"search", "CGENERAL", false},
+		{"search", "CRANDOM", true},
+		{"img", "CGENERAL", false},
+		{"img", "COTHER", true},
+		{"img", "CRANDOM", true},
+	}
+	for _, test := range tests {
+		c := &Command{Name: test.command, Module: "web"}
+		if test.command == "pts" {
+			c.Module = "points"
+		}
+		if e := Enabled(c, test.channel); e != test.enabled {
+			t.Errorf("%s in %s: expected %v, got %v",
+				test.command, test.channel, test.enabled, e)
+		}
+	}
+}
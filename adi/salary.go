@@ -0,0 +1,156 @@
+package adi
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	SalaryPresence = "presence"
+	SalaryActivity = "activity"
+)
+
+var (
+	activityMu sync.Mutex
+	// activity counts the messages of every user since the last salary
+	activity = map[string]uint64{}
+)
+
+// defaultSalary fills in the salary policy for banks that have none,
+// which is 1 point per minute of presence. The state has to be locked.
+func defaultSalary() {
+	sal := &GlobalBank.Salary
+	Changed()
+	if sal.Every == 0 {
+		sal.Every = time.Minute
+		if sal.Mode == "" && sal.Rate == 0 {
+			sal.Rate = 1
+		}
+	}
+	if sal.Mode == "" {
+		sal.Mode = SalaryPresence
+	}
+	if sal.Earned == nil {
+		sal.Earned = map[string]Points{}
+	}
+}
+
+func recordActivity(channel, user string) {
+	if user == "" {
+		return
+	}
+	RLock()
+	excluded := contains(GlobalBank.Salary.ExcludedChannels, channel)
+	RUnlock()
+	if excluded {
+		return
+	}
+	activityMu.Lock()
+	activity[user]++
+	activityMu.Unlock()
+}
+
+func contains(ss []string, s string) bool {
+	for _, o := range ss {
+		if o == s {
+			return true
+		}
+	}
+	return false
+}
+
+// salaryMultiplier returns the multiplier of the highest level in the
+// policy that is not above l.
+func salaryMultiplier(l Level) float64 {
+	m := 1.0
+	var best Level
+	found := false
+	for ml, f := range GlobalBank.Salary.LevelMultipliers {
+		if ml <= l && (!found || ml > best) {
+			best = ml
+			m = f
+			found = true
+		}
+	}
+	return m
+}
+
+// EarnedToday returns the salary user got today. The state has to be
+// locked.
+func EarnedToday(user string) Points {
+	if GlobalBank.Salary.Day != time.Now().UTC().Format("2006-01-02") {
+		return 0
+	}
+	return GlobalBank.Salary.Earned[user]
+}
+
+// paySalary pays every user that was present or, in activity mode,
+// posted messages since the last payment. The state has to be locked.
+func paySalary(us []ChatUser, now time.Time) {
+	sal := &GlobalBank.Salary
+	if now.Sub(sal.LastPaid) < sal.Every {
+		return
+	}
+	sal.LastPaid = now
+	Changed()
+	if day := now.Format("2006-01-02"); sal.Day != day {
+		sal.Day = day
+		sal.Earned = map[string]Points{}
+	}
+	activityMu.Lock()
+	counts := activity
+	activity = map[string]uint64{}
+	activityMu.Unlock()
+	for _, o := range us {
+		if o.IsBot || contains(sal.ExcludedUsers, o.ID) {
+			continue
+		}
+		var n Points
+		switch sal.Mode {
+		case SalaryActivity:
+			c := counts[o.ID]
+			if MulOverflows(uint64(sal.Rate), c) {
+				n = Points(^uint64(0))
+			} else {
+				n = sal.Rate * Points(c)
+			}
+		default:
+			if o.Presence != "active" {
+				continue
+			}
+			n = sal.Rate
+		}
+		if n == 0 {
+			continue
+		}
+		uo := GetCreateUser(o.ID)
+		n = Points(float64(n) * salaryMultiplier(uo.Level))
+		if sal.DailyCap > 0 {
+			e := sal.Earned[o.ID]
+			if e >= sal.DailyCap {
+				continue
+			}
+			if n > sal.DailyCap-e {
+				n = sal.DailyCap - e
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		n = Transfer(BankAccount(), UserAccount(uo), n, "salary", "")
+		sal.Earned[o.ID] += n
+		if GlobalBank.Points == 0 {
+			break
+		}
+	}
+}
+
+// Tick draws the lottery and pays salaries when they are due.
+func Tick(t Transport, now time.Time) {
+	us := ChatUsers()
+	Lock()
+	defer Unlock()
+	drawLottery(t)
+	paySalary(us, now)
+	pruneLimits(now)
+}
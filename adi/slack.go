@@ -0,0 +1,114 @@
+package adi
+
+import (
+	"log"
+
+	"github.com/nlopes/slack"
+)
+
+type slackTransport struct {
+	rtm *slack.RTM
+	bot *slack.User
+}
+
+func toChatUser(u *slack.User) ChatUser {
+	return ChatUser{
+		ID:          u.ID,
+		Name:        u.Name,
+		DisplayName: u.Profile.DisplayName,
+		IsBot:       u.IsBot || u.ID == "USLACKBOT",
+		Presence:    u.Presence,
+	}
+}
+
+func (st *slackTransport) SendMessage(channel, text string) {
+	st.rtm.SendMessage(st.rtm.NewOutgoingMessage(text, channel))
+}
+
+func (st *slackTransport) Typing(channel string) {
+	st.rtm.SendMessage(st.rtm.NewTypingMessage(channel))
+}
+
+func (st *slackTransport) PostMessage(channel string, r Response) error {
+	_, _, err := st.rtm.PostMessage(channel,
+		slack.MsgOptionText(r.Text, false),
+		slack.MsgOptionPostMessageParameters(slack.PostMessageParameters{
+			Parse:       "full",
+			UnfurlLinks: r.UnfurlLinks,
+			UnfurlMedia: r.UnfurlLinks,
+			AsUser:      true,
+			Username:    st.bot.Name,
+			IconURL:     st.bot.Profile.ImageOriginal,
+		}))
+	return err
+}
+
+func (st *slackTransport) GetUsers() ([]ChatUser, error) {
+	us, err := st.rtm.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	cus := make([]ChatUser, len(us))
+	for i, _ := range us {
+		cus[i] = toChatUser(&us[i])
+	}
+	return cus, nil
+}
+
+func (st *slackTransport) GetUserInfo(id string) (*ChatUser, error) {
+	u, err := st.rtm.GetUserInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	cu := toChatUser(u)
+	return &cu, nil
+}
+
+func (st *slackTransport) GetChannels() ([]Channel, error) {
+	cs, err := st.rtm.GetChannels(true)
+	if err != nil {
+		return nil, err
+	}
+	chs := make([]Channel, len(cs))
+	for i, c := range cs {
+		chs[i] = Channel{ID: c.ID, Name: c.Name}
+	}
+	return chs, nil
+}
+
+func (st *slackTransport) GetGroups() ([]Channel, error) {
+	gs, err := st.rtm.GetGroups(true)
+	if err != nil {
+		return nil, err
+	}
+	chs := make([]Channel, len(gs))
+	for i, g := range gs {
+		chs[i] = Channel{ID: g.ID, Name: g.Name, Private: true}
+	}
+	return chs, nil
+}
+
+func (st *slackTransport) OpenIMChannel(user string) (string, error) {
+	_, _, id, err := st.rtm.OpenIMChannel(user)
+	return id, err
+}
+
+func (st *slackTransport) DeleteMessage(channel, timestamp string) error {
+	_, _, err := st.rtm.DeleteMessage(channel, timestamp)
+	return err
+}
+
+// syncDirectory refreshes the directory and subscribes to presence
+// changes of all users.
+func syncDirectory(rtm *slack.RTM, t Transport) {
+	if err := SyncDirectory(t); err != nil {
+		log.Println("ERROR:", err)
+		return
+	}
+	us := ChatUsers()
+	ids := make([]string, len(us))
+	for i, u := range us {
+		ids[i] = u.ID
+	}
+	rtm.SendMessage(rtm.NewSubscribeUserPresence(ids))
+}
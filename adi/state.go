@@ -0,0 +1,72 @@
+package adi
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// state guards Users, Commands, GlobalBank and everything reachable
+// from them. Commands run concurrently, so funcs have to hold it while
+// they use any of those.
+var state sync.RWMutex
+
+// changed is set by Changed and reset once the state is saved.
+var changed bool
+
+func Lock() { state.Lock() }
+
+// Unlock saves the state, if it changed and a store is set, before
+// unlocking it.
+func Unlock() {
+	if changed {
+		saveState()
+		changed = false
+	}
+	state.Unlock()
+}
+
+// Changed marks the state as changed, so it is saved by the next
+// Unlock. Funcs that change Users, Commands, GlobalBank or Rules
+// themselves have to call it. The functions of adi that change them
+// call it already. The state has to be locked.
+func Changed() { changed = true }
+
+func RLock() { state.RLock() }
+
+// runKey is the key of the run state in the context of a message.
+type runKey struct{}
+
+// The run state of a command is running until it locks the state to
+// change it or runs out of time, whichever comes first.
+const (
+	running int32 = iota
+	changing
+	timedOut
+)
+
+// LockChange locks the state for the command of m to change it. If the
+// command already ran out of time it returns false and leaves the state
+// unlocked, then the command must not change anything. Once it returned
+// true the command is waited for, even if it takes too long.
+func LockChange(m Message) bool {
+	Lock()
+	if m.Context == nil {
+		return true
+	}
+	rs, ok := m.Context.Value(runKey{}).(*int32)
+	if ok && !atomic.CompareAndSwapInt32(rs, running, changing) &&
+		atomic.LoadInt32(rs) != changing {
+		Unlock()
+		return false
+	}
+	return true
+}
+
+// withRunState returns ctx with a new run state for LockChange.
+func withRunState(ctx context.Context) (context.Context, *int32) {
+	rs := new(int32)
+	return context.WithValue(ctx, runKey{}, rs), rs
+}
+
+func RUnlock() { state.RUnlock() }
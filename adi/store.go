@@ -0,0 +1,188 @@
+package adi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrEmptyStore is returned by Load if nothing was saved yet.
+var ErrEmptyStore = errors.New("store is empty")
+
+// Store persists users, commands, the bank and the channel rules. Save
+// is called with the state locked after it changed.
+type Store interface {
+	Load(users *[]*User, commands *[]*Command, bank *Bank, rules *[]Rule) error
+	Save(users []*User, commands []*Command, bank *Bank, rules []Rule) error
+	Close() error
+}
+
+var store Store
+
+// SetStore makes adi save its state to s whenever it changes.
+func SetStore(s Store) {
+	Lock()
+	store = s
+	Changed()
+	Unlock()
+}
+
+func saveState() {
+	if store == nil {
+		return
+	}
+	if err := store.Save(Users, Commands, &GlobalBank, Rules); err != nil {
+		log.Println("ERROR:", err)
+	}
+}
+
+// DefaultBackupEvery is how old the newest backup of a FileStore has to
+// be before another one is kept.
+const DefaultBackupEvery = time.Hour
+
+// FileStore keeps users.json, commands.json, bank.json and rules.json
+// in a directory. Files are replaced atomically and up to Backups
+// versions of each are kept as name.1 to name.N. As the state is saved
+// on every change, a replaced version is only kept if the newest backup
+// is older than BackupEvery.
+type FileStore struct {
+	Dir         string
+	Backups     int
+	BackupEvery time.Duration
+	written     map[string][]byte
+}
+
+func NewFileStore(dir string, backups int) *FileStore {
+	return &FileStore{
+		Dir:         dir,
+		Backups:     backups,
+		BackupEvery: DefaultBackupEvery,
+		written:     map[string][]byte{},
+	}
+}
+
+func (fs *FileStore) Load(users *[]*User, commands *[]*Command, bank *Bank, rules *[]Rule) error {
+	readDump := func(file string, item interface{}) error {
+		data, err := ioutil.ReadFile(filepath.Join(fs.Dir, file))
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, item); err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		fs.written[file] = data
+		return nil
+	}
+	if err := readDump("commands.json", commands); err != nil {
+		return err
+	}
+	if err := readDump("users.json", users); err != nil {
+		return err
+	}
+	if err := readDump("bank.json", bank); err != nil {
+		return err
+	}
+	// rules.json is newer than the other files
+	if err := readDump("rules.json", rules); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (fs *FileStore) Save(users []*User, commands []*Command, bank *Bank, rules []Rule) error {
+	if err := fs.writeDump("users.json", users); err != nil {
+		return err
+	}
+	if err := fs.writeDump("commands.json", commands); err != nil {
+		return err
+	}
+	if err := fs.writeDump("bank.json", bank); err != nil {
+		return err
+	}
+	return fs.writeDump("rules.json", rules)
+}
+
+func (fs *FileStore) Close() error {
+	return nil
+}
+
+// writeDump writes item to a temporary file and renames it to file
+// once it is synced, so a crash never leaves a half written file.
+func (fs *FileStore) writeDump(file string, item interface{}) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if bytes.Equal(fs.written[file], data) {
+		return nil
+	}
+	fd, err := ioutil.TempFile(fs.Dir, "."+file+".")
+	if err != nil {
+		return err
+	}
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+	path := filepath.Join(fs.Dir, file)
+	if err := fs.rotate(path); err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+	if err := os.Rename(fd.Name(), path); err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+	if dir, err := os.Open(fs.Dir); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	fs.written[file] = data
+	return nil
+}
+
+// rotate moves path to path.1, path.1 to path.2 and so on, dropping
+// the oldest backup, unless path.1 was written less than BackupEvery
+// ago.
+func (fs *FileStore) rotate(path string) error {
+	if fs.Backups <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	if fi, err := os.Stat(path + ".1"); err == nil &&
+		time.Since(fi.ModTime()) < fs.BackupEvery {
+		return nil
+	}
+	for i := fs.Backups - 1; i > 0; i-- {
+		o := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(o); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(o, fmt.Sprintf("%s.%d", path, i+1)); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(path + ".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Link(path, path+".1")
+}
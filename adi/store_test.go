@@ -0,0 +1,229 @@
+package adi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, st Store) {
+	users := []*User{{ID: "U1", Level: 3, Points: 10}}
+	commands := []*Command{{
+		Name:    "pts",
+		Price:   2,
+		Visible: true,
+		Timeout: time.Second,
+	}}
+	var bank Bank
+	bank.Points = 100
+	bank.Lottery.Tickets = map[string]uint64{"U1": 2}
+	rules := []Rule{{Channel: "C1", Module: "google", Enabled: false}}
+	if err := st.Save(users, commands, &bank, rules); err != nil {
+		t.Fatal(err)
+	}
+	users = append(users, &User{ID: "U2", Points: 5})
+	bank.Points = 95
+	if err := st.Save(users, commands, &bank, rules); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		lusers    []*User
+		lcommands []*Command
+		lbank     Bank
+		lrules    []Rule
+	)
+	if err := st.Load(&lusers, &lcommands, &lbank, &lrules); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(users, lusers) {
+		t.Errorf("users differ: %v %v", users, lusers)
+	}
+	if !reflect.DeepEqual(commands, lcommands) {
+		t.Errorf("commands differ: %v %v", commands, lcommands)
+	}
+	if !reflect.DeepEqual(bank, lbank) {
+		t.Errorf("bank differs: %v %v", bank, lbank)
+	}
+	if !reflect.DeepEqual(rules, lrules) {
+		t.Errorf("rules differ: %v %v", rules, lrules)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testStore(t, NewFileStore(dir, 1))
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	expected := []string{
+		"bank.json", "bank.json.1",
+		"commands.json",
+		"rules.json",
+		"users.json", "users.json.1",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected files %v, got %v", expected, names)
+	}
+	var bank Bank
+	var users []*User
+	var commands []*Command
+	var rules []Rule
+	if err := NewFileStore(dir, 0).Load(
+		&users, &commands, &bank, &rules); err != nil {
+		t.Fatal(err)
+	}
+	if bank.Points != 95 {
+		t.Errorf("expected bank to have 95 points, got %d", bank.Points)
+	}
+}
+
+func TestFileStoreBackupEvery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	st := NewFileStore(dir, 2)
+	backups := func() []Points {
+		var ps []Points
+		for i := 1; i <= 2; i++ {
+			var bank Bank
+			data, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("bank.json.%d", i)))
+			if os.IsNotExist(err) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(data, &bank); err != nil {
+				t.Fatal(err)
+			}
+			ps = append(ps, bank.Points)
+		}
+		return ps
+	}
+	tests := []struct {
+		points  Points
+		age     time.Duration
+		backups []Points
+	}{
+		{1, 0, nil},
+		{2, 0, []Points{1}},
+		{3, 0, []Points{1}},
+		{4, time.Minute, []Points{1}},
+		{5, 2 * time.Hour, []Points{4, 1}},
+		{6, 0, []Points{4, 1}},
+	}
+	for _, test := range tests {
+		if test.age > 0 {
+			old := time.Now().Add(-test.age)
+			if err := os.Chtimes(filepath.Join(dir, "bank.json.1"), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var bank Bank
+		bank.Points = test.points
+		if err := st.Save(nil, nil, &bank, nil); err != nil {
+			t.Fatal(err)
+		}
+		if b := backups(); !reflect.DeepEqual(b, test.backups) {
+			t.Errorf("%d: expected backups %v, got %v", test.points, test.backups, b)
+		}
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "adi.db")
+	bs, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bank Bank
+	var users []*User
+	var commands []*Command
+	var rules []Rule
+	if err := bs.Load(&users, &commands, &bank, &rules); err != ErrEmptyStore {
+		t.Errorf("expected empty store, got %v", err)
+	}
+	testStore(t, bs)
+	// users that are gone have to be deleted, also after a restart
+	if err := bs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if bs, err = NewBoltStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+	if err := bs.Load(&users, &commands, &bank, &rules); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Save(users[:1], commands, &bank, rules); err != nil {
+		t.Fatal(err)
+	}
+	users = nil
+	if err := bs.Load(&users, &commands, &bank, &rules); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].ID != "U1" {
+		t.Errorf("expected only U1 to be left, got %v", users)
+	}
+}
+
+// countingStore counts how often it is saved.
+type countingStore struct {
+	saves int
+}
+
+func (cs *countingStore) Load(users *[]*User, commands *[]*Command, bank *Bank, rules *[]Rule) error {
+	return nil
+}
+
+func (cs *countingStore) Save(users []*User, commands []*Command, bank *Bank, rules []Rule) error {
+	cs.saves++
+	return nil
+}
+
+func (cs *countingStore) Close() error {
+	return nil
+}
+
+func TestSaveOnChange(t *testing.T) {
+	cs := &countingStore{}
+	SetStore(cs)
+	defer SetStore(nil)
+	Lock()
+	GetCreateUser("U1")
+	Unlock()
+	saves := cs.saves
+	Lock()
+	GetCreateUser("U1")
+	Unlock()
+	if cs.saves != saves {
+		t.Errorf("expected no save without a change, got %d", cs.saves-saves)
+	}
+	Lock()
+	Changed()
+	Unlock()
+	if cs.saves != saves+1 {
+		t.Errorf("expected a save after a change, got %d", cs.saves-saves)
+	}
+}
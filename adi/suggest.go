@@ -0,0 +1,107 @@
+package adi
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSuggestions is how many commands are suggested for an unknown one.
+const maxSuggestions = 3
+
+// errUnknownCommand is returned by parseCommand for text that does not
+// start with a command.
+var errUnknownCommand = errors.New("unknown command")
+
+// unknownCommand returns the error for text that does not start with a
+// command, suggesting the commands u may run in channel that are
+// spelled alike. The state has to be locked.
+func unknownCommand(text string, u *User, channel string) error {
+	fs := strings.Fields(text)
+	if len(fs) == 0 {
+		return errors.New("try 'help' to see what I can do")
+	}
+	sugs := suggestCommands(strings.ToLower(fs[0]), u, channel)
+	if len(sugs) == 0 {
+		return fmt.Errorf("unknown command %s. try 'help' to see what I can do",
+			fs[0])
+	}
+	return fmt.Errorf("unknown command %s. did you mean %s?",
+		fs[0], strings.Join(sugs, ", "))
+}
+
+// suggestCommands returns the names of the visible and proxy commands
+// u may run in channel that start with word, are the start of word or
+// are only a few typos away from it, best match first.
+func suggestCommands(word string, u *User, channel string) []string {
+	type suggestion struct {
+		name   string
+		prefix bool
+		dist   int
+	}
+	var sugs []suggestion
+	for _, c := range Commands {
+		if !c.Visible && c.Proxy == "" || !Allowed(u, c) || !Enabled(c, channel) {
+			continue
+		}
+		prefix := utf8.RuneCountInString(word) >= 2 &&
+			(strings.HasPrefix(c.Name, word) || strings.HasPrefix(word, c.Name))
+		dist := levenshtein(word, c.Name)
+		if !prefix && (dist > 2 || dist >= utf8.RuneCountInString(c.Name)) {
+			continue
+		}
+		sugs = append(sugs, suggestion{c.Name, prefix, dist})
+	}
+	sort.Slice(sugs, func(i, j int) bool {
+		if sugs[i].prefix != sugs[j].prefix {
+			return sugs[i].prefix
+		}
+		if sugs[i].dist != sugs[j].dist {
+			return sugs[i].dist < sugs[j].dist
+		}
+		return sugs[i].name < sugs[j].name
+	})
+	if len(sugs) > maxSuggestions {
+		sugs = sugs[:maxSuggestions]
+	}
+	names := make([]string, len(sugs))
+	for i, s := range sugs {
+		names[i] = s.name
+	}
+	return names
+}
+
+// levenshtein returns how many runes have to be inserted, deleted or
+// replaced to turn a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
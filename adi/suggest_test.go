@@ -0,0 +1,57 @@
+package adi
+
+import "testing"
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		dist int
+	}{
+		{"", "", 0},
+		{"pts", "pts", 0},
+		{"pst", "pts", 2},
+		{"ptss", "pts", 1},
+		{"duell", "duel", 1},
+		{"kitten", "sitting", 3},
+		{"ä", "a", 1},
+	}
+	for _, test := range tests {
+		if d := levenshtein(test.a, test.b); d != test.dist {
+			t.Errorf("%q %q: expected %d, got %d", test.a, test.b, test.dist, d)
+		}
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	Commands = []*Command{
+		{Name: "pts", Visible: true},
+		{Name: "trpts", Visible: true},
+		{Name: "givepts", Visible: true},
+		{Name: "duel", Visible: true},
+		{Name: "setlvl", Visible: false},
+		{Name: "mine", Proxy: "pts"},
+		{Name: "dual", Visible: true, RequiredLevel: 5},
+		{Name: "duels", Visible: true},
+	}
+	Rules = []Rule{{Channel: "C1", Command: "duels", Enabled: false}}
+	defer func() { Rules = nil }()
+	u := &User{ID: "U1", Level: 1}
+	tests := []struct {
+		text string
+		err  string
+	}{
+		{"", "try 'help' to see what I can do"},
+		{"ptsbob", "unknown command ptsbob. did you mean pts?"},
+		{"pst", "unknown command pst. did you mean pts?"},
+		{"Duell bob 5", "unknown command Duell. did you mean duel?"},
+		{"tpts", "unknown command tpts. did you mean pts, trpts?"},
+		{"min", "unknown command min. did you mean mine?"},
+		{"setlv", "unknown command setlv. try 'help' to see what I can do"},
+		{"x", "unknown command x. try 'help' to see what I can do"},
+	}
+	for _, test := range tests {
+		if err := unknownCommand(test.text, u, "C1"); err.Error() != test.err {
+			t.Errorf("%q: expected %q, got %q", test.text, test.err, err)
+		}
+	}
+}
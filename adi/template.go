@@ -0,0 +1,237 @@
+package adi
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A proxy runs a template in which placeholders are replaced by what
+// the proxy was given:
+//
+//	$1, $2, ...   the first, second, ... argument
+//	$@            all arguments as they were written
+//	${1:-text}    the first argument or text if it was not given
+//	$user         the name of the user running the proxy
+//	$channel      the name of the channel it runs in
+//	$$            a literal $
+//
+// A $ that is not followed by a name, number, @, { or $ is kept as is.
+// Old templates without placeholders may use %s instead of $@. A ;
+// outside of double quotes separates commands that are run one after
+// another, like "weather berlin; weather paris".
+
+// oldPlaceholder matches what parseTemplate reads as placeholder.
+var oldPlaceholder = regexp.MustCompile(`\$(\$|@|\{[^}]*\}|[0-9a-z]+)`)
+
+// templatePart is literal text or, if name is set, a placeholder.
+type templatePart struct {
+	text   string
+	name   string
+	def    string
+	hasDef bool
+}
+
+func isTemplateName(name string) bool {
+	if name == "@" || name == "user" || name == "channel" {
+		return true
+	}
+	n, err := strconv.Atoi(name)
+	return err == nil && n > 0
+}
+
+func parseTemplate(s string) ([]templatePart, error) {
+	if !strings.Contains(s, "$") && strings.Contains(s, "%s") {
+		s = strings.Replace(s, "%s", "$@", -1)
+	}
+	var parts []templatePart
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			parts = append(parts, templatePart{text: lit.String()})
+			lit.Reset()
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			lit.WriteByte(s[i])
+			continue
+		}
+		c := s[i+1]
+		switch {
+		case c == '$':
+			lit.WriteByte('$')
+			i++
+		case c == '@':
+			flush()
+			parts = append(parts, templatePart{name: "@"})
+			i++
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end == -1 {
+				return nil, errors.New("${ is not closed")
+			}
+			p := templatePart{name: s[i+2 : i+end]}
+			if o := strings.Index(p.name, ":-"); o != -1 {
+				p.name, p.def, p.hasDef = p.name[:o], p.name[o+2:], true
+			}
+			if !isTemplateName(p.name) {
+				return nil, fmt.Errorf("unknown placeholder ${%s}", p.name)
+			}
+			flush()
+			parts = append(parts, p)
+			i += end
+		case c >= '0' && c <= '9' || c >= 'a' && c <= 'z':
+			end := i + 1
+			for end < len(s) && (s[end] >= '0' && s[end] <= '9' ||
+				s[end] >= 'a' && s[end] <= 'z') {
+				end++
+			}
+			name := s[i+1 : end]
+			if !isTemplateName(name) {
+				return nil, fmt.Errorf("unknown placeholder $%s", name)
+			}
+			flush()
+			parts = append(parts, templatePart{name: name})
+			i = end - 1
+		default:
+			lit.WriteByte('$')
+		}
+	}
+	flush()
+	// the command has to be known before anything is replaced
+	if len(parts) == 0 || parts[0].name != "" {
+		return nil, errors.New("a proxy has to start with a command")
+	}
+	first := strings.TrimLeftFunc(parts[0].text, unicode.IsSpace)
+	if first == "" || len(parts) > 1 && strings.IndexFunc(first, unicode.IsSpace) == -1 {
+		return nil, errors.New("a proxy has to start with a command")
+	}
+	return parts, nil
+}
+
+// splitSteps splits a template at every ; outside of double quotes.
+func splitSteps(s string) []string {
+	var steps []string
+	start := 0
+	quote := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quote = !quote
+		case ';':
+			if !quote {
+				steps = append(steps, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(steps, s[start:])
+}
+
+// CheckTemplate returns an error if s can not be used as proxy.
+func CheckTemplate(s string) error {
+	for _, step := range splitSteps(s) {
+		if _, err := parseTemplate(step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// oldTemplateChanges describes what the proxy template s means other
+// than before placeholders and steps, when a ; and every $ were kept as
+// is and %s was replaced by the arguments.
+func oldTemplateChanges(s string) []string {
+	var changes []string
+	steps := splitSteps(s)
+	if len(steps) > 1 {
+		changes = append(changes, "; separates commands")
+	}
+	seen := map[string]bool{}
+	for _, step := range steps {
+		for _, p := range oldPlaceholder.FindAllString(step, -1) {
+			if !seen[p] {
+				seen[p] = true
+				changes = append(changes, p+" is a placeholder")
+			}
+		}
+		if strings.Contains(step, "$") && strings.Contains(step, "%s") &&
+			!seen["%s"] {
+			seen["%s"] = true
+			changes = append(changes, "%s is kept as is")
+		}
+	}
+	return changes
+}
+
+// ProxyTargets returns the names of the commands the proxy template s
+// runs.
+func ProxyTargets(s string) []string {
+	var names []string
+	for _, step := range splitSteps(s) {
+		if fs := strings.Fields(step); len(fs) > 0 {
+			names = append(names, fs[0])
+		}
+	}
+	return names
+}
+
+// expandTemplate returns the commands the proxy name runs when it is
+// given params by user in channel.
+func expandTemplate(name, s, params, user, channel string) ([]string, error) {
+	steps := splitSteps(s)
+	texts := make([]string, len(steps))
+	for i, step := range steps {
+		text, err := expandStep(name, step, params, user, channel)
+		if err != nil {
+			return nil, err
+		}
+		texts[i] = strings.TrimSpace(text)
+	}
+	return texts, nil
+}
+
+func expandStep(name, s, params, user, channel string) (string, error) {
+	parts, err := parseTemplate(s)
+	if err != nil {
+		return "", fmt.Errorf("%s is broken: %s", name, err)
+	}
+	args := splitArgs(params)
+	var b strings.Builder
+	for _, p := range parts {
+		switch p.name {
+		case "":
+			b.WriteString(p.text)
+			continue
+		case "user":
+			b.WriteString(NameOf(user))
+			continue
+		case "channel":
+			b.WriteString(channelNameOf(channel))
+			continue
+		case "@":
+			if params = strings.TrimSpace(params); params != "" || !p.hasDef {
+				b.WriteString(params)
+			} else {
+				b.WriteString(p.def)
+			}
+			continue
+		}
+		n, _ := strconv.Atoi(p.name)
+		switch {
+		case n <= len(args) && args[n-1].quoted:
+			b.WriteString(`"` + args[n-1].text + `"`)
+		case n <= len(args):
+			b.WriteString(args[n-1].text)
+		case p.hasDef:
+			b.WriteString(p.def)
+		default:
+			return "", fmt.Errorf("%s needs argument $%d", name, n)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,60 @@
+package adi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	PutUser(ChatUser{ID: "U1", Name: "alice"})
+	PutChannel(Channel{ID: "C1", Name: "general"})
+	tests := []struct {
+		template string
+		params   string
+		command  string
+		err      string
+	}{
+		{"pts", "bob", "pts", ""},
+		{"pts %s", "bob", "pts bob", ""},
+		{"calc 100% of %s", "5", "calc 100% of 5", ""},
+		{"say $@ now", `"a b" c`, `say "a b" c now`, ""},
+		{"duel $2 $1", `10 "some one"`, `duel "some one" 10`, ""},
+		{"givepts $1 ${2:-5}", "bob", "givepts bob 5", ""},
+		{"rnd ${@:-a,b}", "", "rnd a,b", ""},
+		{"sayin $channel hi $user", "", "sayin general hi alice", ""},
+		{"calc 2$ + $$1", "", "calc 2$ + $1", ""},
+		{"pts $1;rank ${2:-5}", "bob", "pts bob; rank 5", ""},
+		{`say "a;b"; say $1`, "c", `say "a;b"; say c`, ""},
+		{"pts $1", "", "", "p needs argument $1"},
+		{"pts $usr", "", "", "p is broken: unknown placeholder $usr"},
+		{"pts ${0}", "", "", "p is broken: unknown placeholder ${0}"},
+	}
+	for _, test := range tests {
+		cs, err := expandTemplate("p", test.template, test.params, "U1", "C1")
+		c := strings.Join(cs, "; ")
+		if c != test.command || errString(err) != test.err {
+			t.Errorf("%q %q: expected %q %q, got %q %v", test.template,
+				test.params, test.command, test.err, c, err)
+		}
+	}
+}
+
+func TestOldTemplateChanges(t *testing.T) {
+	tests := []struct {
+		template string
+		changes  string
+	}{
+		{"pts %s", ""},
+		{"calc 2$ + 3", ""},
+		{"calc 100% of %s", ""},
+		{"say a; b", "; separates commands"},
+		{`say "a;b"`, ""},
+		{"say $1 costs $5", "$1 is a placeholder, $5 is a placeholder"},
+		{"say $$ ${1:-x} $@ %s", "$$ is a placeholder, ${1:-x} is a placeholder, $@ is a placeholder, %s is kept as is"},
+	}
+	for _, test := range tests {
+		if c := strings.Join(oldTemplateChanges(test.template), ", "); c != test.changes {
+			t.Errorf("%q: expected %q, got %q", test.template, test.changes, c)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package adi
+
+type ChatUser struct {
+	ID          string
+	Name        string
+	DisplayName string
+	IsBot       bool
+	Presence    string
+}
+
+type Channel struct {
+	ID      string
+	Name    string
+	Private bool
+}
+
+// Transport is the chat service adi is connected to. Commands only
+// talk to the chat through it so they do not depend on slack directly.
+type Transport interface {
+	SendMessage(channel, text string)
+	// Typing shows that adi is working on something in channel.
+	Typing(channel string)
+	PostMessage(channel string, r Response) error
+	GetUsers() ([]ChatUser, error)
+	GetUserInfo(id string) (*ChatUser, error)
+	GetChannels() ([]Channel, error)
+	GetGroups() ([]Channel, error)
+	OpenIMChannel(user string) (string, error)
+	DeleteMessage(channel, timestamp string) error
+}